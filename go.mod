@@ -13,4 +13,5 @@ require (
 	k8s.io/apimachinery v0.17.1
 	k8s.io/client-go v0.0.0-20190620085101-78d2af792bab
 	k8s.io/utils v0.0.0-20200124190032-861946025e34 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,243 @@
+package sntt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResultsFileVersion is the version of the results file format, which also serves as baseline for compare.
+const ResultsFileVersion = 1
+
+// ResultsFile is the structured results of a run.
+type ResultsFile struct {
+	Version        int             `json:"version"`
+	GeneratedAt    time.Time       `json:"generatedAt"`
+	Results        []ProbeResult   `json:"results"`
+	UncoveredNodes []UncoveredNode `json:"uncoveredNodes,omitempty"`
+}
+
+// ProbeDiff is a probe whose outcome changed between the baseline and the current run.
+type ProbeDiff struct {
+	Key                   string  `json:"key"`
+	BaselineSuccess       bool    `json:"baselineSuccess"`
+	CurrentSuccess        bool    `json:"currentSuccess"`
+	BaselineLatencyMillis float64 `json:"baselineLatencyMillis,omitempty"`
+	CurrentLatencyMillis  float64 `json:"currentLatencyMillis,omitempty"`
+}
+
+// Comparison is the difference between the baseline and the current run.
+type Comparison struct {
+	BecameUnreachable  []ProbeDiff `json:"becameUnreachable,omitempty"`
+	BecameReachable    []ProbeDiff `json:"becameReachable,omitempty"`
+	LatencyRegressions []ProbeDiff `json:"latencyRegressions,omitempty"`
+	NewUncoveredNodes  []string    `json:"newUncoveredNodes,omitempty"`
+	NewProbes          []string    `json:"newProbes,omitempty"`
+	MissingProbes      []string    `json:"missingProbes,omitempty"`
+}
+
+// HasRegression is true when a probe became unreachable, got slower beyond the threshold or a node lost coverage.
+func (c *Comparison) HasRegression() bool {
+	return len(c.BecameUnreachable) > 0 || len(c.LatencyRegressions) > 0 || len(c.NewUncoveredNodes) > 0
+}
+
+func makeResultsFile() *ResultsFile {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	return &ResultsFile{
+		Version:        ResultsFileVersion,
+		GeneratedAt:    time.Now(),
+		Results:        append([]ProbeResult{}, probeResults...),
+		UncoveredNodes: append([]UncoveredNode{}, uncoveredNodes...),
+	}
+}
+
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func readResultsFile(path string) (*ResultsFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	resultsFile := &ResultsFile{}
+	if err := json.Unmarshal(data, resultsFile); err != nil {
+		return nil, fmt.Errorf("failed to parse results file %s: %v", path, err)
+	}
+	if resultsFile.Version != ResultsFileVersion {
+		return nil, fmt.Errorf("results file %s has version %d, expected %d", path, resultsFile.Version, ResultsFileVersion)
+	}
+
+	return resultsFile, nil
+}
+
+// getProbeKey identifies a probe across runs. Pod names, IPs and the testing namespace change every run, so probes
+// are identified by the nodes and the name of the target, never by Target.
+func getProbeKey(result ProbeResult) string {
+	return fmt.Sprintf("[%s] %s => %s (%s)", result.Case, joinNonEmpty(result.SourceCluster, result.SourceNode),
+		joinNonEmpty(result.TargetCluster, result.TargetNode, result.TargetName), result.Kind)
+}
+
+type probeSummary struct {
+	success bool
+	latency float64
+}
+
+// summarizeProbes merges the results of every probe key: a probe succeeds only when all of its results succeeded,
+// and its latency is the mean latency of its successful results.
+func summarizeProbes(results []ProbeResult) map[string]*probeSummary {
+	summaries := map[string]*probeSummary{}
+	latencyCounts := map[string]int{}
+	for _, result := range results {
+		key := getProbeKey(result)
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &probeSummary{success: true}
+			summaries[key] = summary
+		}
+		summary.success = summary.success && result.Success
+		if result.Success && result.LatencyMillis > 0 {
+			summary.latency += result.LatencyMillis
+			latencyCounts[key]++
+		}
+	}
+	for key, count := range latencyCounts {
+		summaries[key].latency /= float64(count)
+	}
+
+	return summaries
+}
+
+// compareResults compares the current run with the baseline. A latency regression is a latency increase of more than
+// threshold times the baseline latency and more than minMillis.
+func compareResults(baseline *ResultsFile, current *ResultsFile, threshold float64, minMillis float64) *Comparison {
+	comparison := &Comparison{}
+	baselineProbes := summarizeProbes(baseline.Results)
+	currentProbes := summarizeProbes(current.Results)
+
+	for key, currentProbe := range currentProbes {
+		baselineProbe, ok := baselineProbes[key]
+		if !ok {
+			comparison.NewProbes = append(comparison.NewProbes, key)
+			continue
+		}
+		diff := ProbeDiff{
+			Key:                   key,
+			BaselineSuccess:       baselineProbe.success,
+			CurrentSuccess:        currentProbe.success,
+			BaselineLatencyMillis: baselineProbe.latency,
+			CurrentLatencyMillis:  currentProbe.latency,
+		}
+		switch {
+		case baselineProbe.success && !currentProbe.success:
+			comparison.BecameUnreachable = append(comparison.BecameUnreachable, diff)
+		case !baselineProbe.success && currentProbe.success:
+			comparison.BecameReachable = append(comparison.BecameReachable, diff)
+		case baselineProbe.latency > 0 && currentProbe.latency > 0:
+			increase := currentProbe.latency - baselineProbe.latency
+			if increase > baselineProbe.latency*threshold && increase > minMillis {
+				comparison.LatencyRegressions = append(comparison.LatencyRegressions, diff)
+			}
+		}
+	}
+	for key := range baselineProbes {
+		if _, ok := currentProbes[key]; !ok {
+			comparison.MissingProbes = append(comparison.MissingProbes, key)
+		}
+	}
+
+	baselineUncovered := map[string]bool{}
+	for _, uncoveredNode := range baseline.UncoveredNodes {
+		baselineUncovered[uncoveredNode.Node] = true
+	}
+	for _, uncoveredNode := range current.UncoveredNodes {
+		if !baselineUncovered[uncoveredNode.Node] && !containsString(comparison.NewUncoveredNodes, uncoveredNode.Node) {
+			comparison.NewUncoveredNodes = append(comparison.NewUncoveredNodes, uncoveredNode.Node)
+		}
+	}
+
+	sortProbeDiffs(comparison.BecameUnreachable)
+	sortProbeDiffs(comparison.BecameReachable)
+	sortProbeDiffs(comparison.LatencyRegressions)
+	sort.Strings(comparison.NewUncoveredNodes)
+	sort.Strings(comparison.NewProbes)
+	sort.Strings(comparison.MissingProbes)
+
+	return comparison
+}
+
+func sortProbeDiffs(diffs []ProbeDiff) {
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+}
+
+// formatComparison renders the comparison for the terminal.
+func formatComparison(comparison *Comparison) string {
+	var builder strings.Builder
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&builder, "========== %s (%d) ==========\n", title, len(lines))
+		for _, line := range lines {
+			fmt.Fprintln(&builder, line)
+		}
+	}
+	keys := func(diffs []ProbeDiff) []string {
+		var lines []string
+		for _, diff := range diffs {
+			lines = append(lines, diff.Key)
+		}
+		return lines
+	}
+	var latencyLines []string
+	for _, diff := range comparison.LatencyRegressions {
+		latencyLines = append(latencyLines, fmt.Sprintf("%s : %.3fms => %.3fms", diff.Key, diff.BaselineLatencyMillis, diff.CurrentLatencyMillis))
+	}
+
+	section("became unreachable", keys(comparison.BecameUnreachable))
+	section("latency regressions", latencyLines)
+	section("nodes newly without coverage", comparison.NewUncoveredNodes)
+	section("became reachable", keys(comparison.BecameReachable))
+	section("new probes", comparison.NewProbes)
+	section("probes missing from this run", comparison.MissingProbes)
+	if !comparison.HasRegression() {
+		fmt.Fprintln(&builder, "no regression against the baseline")
+	}
+
+	return builder.String()
+}
+
+// runCompare compares the results file of this run with the baseline, prints the differences and exports them when
+// -compare-output is given. It returns true when there is a regression.
+func runCompare() (bool, string, error) {
+	if *baselineFile == "" || *resultsFile == "" {
+		return false, "", fmt.Errorf("-compare needs -baseline-file and -results-file")
+	}
+	baseline, err := readResultsFile(*baselineFile)
+	if err != nil {
+		return false, "", err
+	}
+	current, err := readResultsFile(*resultsFile)
+	if err != nil {
+		return false, "", err
+	}
+
+	comparison := compareResults(baseline, current, *latencyRegressionThreshold, *latencyRegressionMinMillis)
+	if *compareOutput != "" {
+		if err := writeJSONFile(*compareOutput, comparison); err != nil {
+			return false, "", err
+		}
+	}
+
+	return comparison.HasRegression(), formatComparison(comparison), nil
+}
@@ -0,0 +1,122 @@
+package sntt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetProbeKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		result ProbeResult
+		key    string
+	}{
+		{
+			name: "pod on a node",
+			result: ProbeResult{Case: "A-2", SourceNode: "node-1", SourcePod: "alpha-x7k2p", TargetNode: "node-2",
+				Target: "10.244.1.5", Kind: ProbeKindPod},
+			key: "[A-2] node-1 => node-2 (pod)",
+		},
+		{
+			name: "service by name",
+			result: ProbeResult{Case: "E-3", SourceNode: "node-1", TargetName: EchoServiceName,
+				Target: "http://10.96.12.34:8080/hostname", Kind: ProbeKindService},
+			key: "[E-3] node-1 => echo-service (service)",
+		},
+		{
+			name: "service through a node",
+			result: ProbeResult{Case: "F-2", SourceNode: "node-1", TargetNode: "node-2",
+				Target: "http://192.168.0.2:30080/hostname", Kind: ProbeKindService},
+			key: "[F-2] node-1 => node-2 (service)",
+		},
+		{
+			name: "across clusters",
+			result: ProbeResult{Case: MultiClusterCase, SourceCluster: "east", SourceNode: "node-1", TargetCluster: "west",
+				TargetName: MultiClusterServiceName, Target: "http://10.96.0.20:8080/hostname", Kind: ProbeKindService},
+			key: "[K] east/node-1 => west/sntt-echo (service)",
+		},
+	}
+
+	for _, test := range tests {
+		if key := getProbeKey(test.result); key != test.key {
+			t.Errorf("%s: key %q, expected %q", test.name, key, test.key)
+		}
+	}
+}
+
+func TestGetProbeKeyIgnoresAddresses(t *testing.T) {
+	baseline := ProbeResult{Case: "O", SourceNode: "node-1", SourcePod: "daemonset-abcde", TargetName: EchoServiceName,
+		Target: "10.96.12.34:8080", Kind: ProbeKindService}
+	current := baseline
+	current.SourcePod = "daemonset-fghij"
+	current.Target = "10.96.200.7:8080"
+
+	if getProbeKey(baseline) != getProbeKey(current) {
+		t.Errorf("keys differ by pod and address: %q, %q", getProbeKey(baseline), getProbeKey(current))
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	probe := func(targetNode string, success bool, latency float64) ProbeResult {
+		return ProbeResult{Case: "A-2", SourceNode: "node-1", TargetNode: targetNode, Kind: ProbeKindPod, Success: success,
+			LatencyMillis: latency}
+	}
+	baseline := &ResultsFile{
+		Results: []ProbeResult{
+			probe("node-2", true, 1),
+			probe("node-3", true, 10),
+			probe("node-4", false, 0),
+			probe("node-5", true, 1),
+			probe("node-6", true, 1),
+		},
+		UncoveredNodes: []UncoveredNode{{Node: "node-8"}},
+	}
+	current := &ResultsFile{
+		Results: []ProbeResult{
+			probe("node-2", false, 0),
+			probe("node-3", true, 12),
+			probe("node-4", true, 1),
+			probe("node-5", true, 1),
+			probe("node-5", true, 9),
+			probe("node-7", true, 1),
+		},
+		UncoveredNodes: []UncoveredNode{{Node: "node-8"}, {Node: "node-9"}, {Node: "node-9"}},
+	}
+
+	comparison := compareResults(baseline, current, 0.5, 2)
+	keys := func(diffs []ProbeDiff) []string {
+		var keys []string
+		for _, diff := range diffs {
+			keys = append(keys, diff.Key)
+		}
+		return keys
+	}
+	expected := map[string][]string{
+		"became unreachable":  {"[A-2] node-1 => node-2 (pod)"},
+		"became reachable":    {"[A-2] node-1 => node-4 (pod)"},
+		"latency regressions": {"[A-2] node-1 => node-5 (pod)"},
+		"new uncovered nodes": {"node-9"},
+		"new probes":          {"[A-2] node-1 => node-7 (pod)"},
+		"missing probes":      {"[A-2] node-1 => node-6 (pod)"},
+	}
+	actual := map[string][]string{
+		"became unreachable":  keys(comparison.BecameUnreachable),
+		"became reachable":    keys(comparison.BecameReachable),
+		"latency regressions": keys(comparison.LatencyRegressions),
+		"new uncovered nodes": comparison.NewUncoveredNodes,
+		"new probes":          comparison.NewProbes,
+		"missing probes":      comparison.MissingProbes,
+	}
+	for name, keys := range expected {
+		if !reflect.DeepEqual(actual[name], keys) {
+			t.Errorf("%s %v, expected %v", name, actual[name], keys)
+		}
+	}
+	if !comparison.HasRegression() {
+		t.Error("comparison has no regression")
+	}
+
+	if comparison := compareResults(baseline, baseline, 0.5, 2); comparison.HasRegression() {
+		t.Errorf("baseline regressed against itself: %+v", comparison)
+	}
+}
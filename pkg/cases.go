@@ -0,0 +1,125 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+)
+
+const (
+	TagPodToPod   = "pod-to-pod"
+	TagCrossNode  = "cross-node"
+	TagEgress     = "egress"
+	TagDNS        = "dns"
+	TagPolicy     = "policy"
+	TagService    = "service"
+	TagSlow       = "slow"
+	TagDisruptive = "disruptive"
+)
+
+var knownTags = []string{TagPodToPod, TagCrossNode, TagEgress, TagDNS, TagPolicy, TagService, TagSlow, TagDisruptive}
+
+// caseTags are the tags of every case by the case ID in square brackets of its Describe text.
+var caseTags = map[string][]string{
+	"A-1": {TagPodToPod},
+	"A-2": {TagPodToPod, TagCrossNode},
+	"A-3": {TagPodToPod},
+	"A-4": {TagPodToPod, TagCrossNode},
+	"B":   {TagEgress, TagDNS},
+	"B-2": {TagEgress},
+	"C":   {TagPodToPod, TagPolicy},
+	"D-1": {TagEgress, TagDNS, TagPolicy},
+	"E-1": {TagCrossNode},
+	"E-2": {TagCrossNode},
+	"E-3": {TagService},
+	"F-1": {TagService, TagCrossNode},
+	"F-2": {TagService, TagCrossNode},
+	"F-3": {TagService, TagSlow},
+	"F-4": {TagService, TagSlow},
+	"G-1": {TagService},
+	"G-2": {TagService},
+	"H":   {TagService, TagSlow},
+	"I":   {TagService},
+	"J":   {TagService},
+	"K":   {TagPodToPod, TagService, TagSlow},
+	"L":   {TagPodToPod, TagCrossNode, TagSlow},
+	"M":   {TagPodToPod, TagCrossNode, TagSlow},
+	"N":   {TagPodToPod, TagCrossNode, TagSlow, TagDisruptive},
+	"O":   {TagService, TagSlow},
+	"P":   {TagPodToPod, TagCrossNode, TagService, TagSlow},
+	"Q":   {TagDNS, TagSlow},
+	"R":   {TagDNS},
+	"S":   {TagPodToPod, TagPolicy},
+}
+
+func splitFilter(value string) []string {
+	var filter []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			filter = append(filter, item)
+		}
+	}
+
+	return filter
+}
+
+// getCaseFilters returns the tags and case IDs to include and exclude, from both the test plan and the flags.
+func getCaseFilters() ([]string, []string) {
+	include := append(append([]string{}, testPlan.Include...), splitFilter(*includeCases)...)
+	exclude := append(append([]string{}, testPlan.Exclude...), splitFilter(*excludeCases)...)
+
+	return include, exclude
+}
+
+func validateCaseFilters() error {
+	include, exclude := getCaseFilters()
+	for _, item := range append(include, exclude...) {
+		if _, ok := caseTags[item]; !ok && !containsString(knownTags, item) {
+			return fmt.Errorf("'%s' is neither a case ID nor a tag, tags are %s", item, strings.Join(knownTags, ", "))
+		}
+	}
+
+	return nil
+}
+
+func matchesCase(caseID string, filter []string) bool {
+	for _, item := range filter {
+		if item == caseID || containsString(caseTags[caseID], item) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCaseSelected tells whether the case matches one of the included tags or IDs, every case when nothing is
+// included, and none of the excluded ones.
+func isCaseSelected(caseID string) bool {
+	include, exclude := getCaseFilters()
+	if len(include) > 0 && !matchesCase(caseID, include) {
+		return false
+	}
+
+	return !matchesCase(caseID, exclude)
+}
+
+func skipIfCaseIsNotSelected(caseID string) {
+	if caseID != "" && !isCaseSelected(caseID) {
+		Skip(fmt.Sprintf("case %s with tags %s is not selected", caseID, strings.Join(caseTags[caseID], ",")))
+	}
+}
+
+// getSelectedCaseIDs returns the IDs of the selected cases in order.
+func getSelectedCaseIDs() []string {
+	var caseIDs []string
+	for caseID := range caseTags {
+		if isCaseSelected(caseID) {
+			caseIDs = append(caseIDs, caseID)
+		}
+	}
+	sort.Strings(caseIDs)
+
+	return caseIDs
+}
@@ -0,0 +1,180 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ChurnCase      = "M"
+	ChurnPodPrefix = "churn-"
+)
+
+// ipReuse is a pod IP given to a new pod after an earlier pod with the same IP was deleted.
+type ipReuse struct {
+	IP           string
+	PreviousPod  string
+	PreviousNode string
+	Pod          string
+	Node         string
+}
+
+// misdirectedResponse is a response to a pod IP which did not come from the pod currently holding the IP.
+type misdirectedResponse struct {
+	ProberNode  string
+	IP          string
+	ExpectedPod string
+	AnsweredBy  string
+}
+
+// churnReport is what was observed while pods were deleted and recreated.
+type churnReport struct {
+	Churned     int
+	Reuses      []ipReuse
+	Misdirected []misdirectedResponse
+	Blackholed  []string
+}
+
+func formatChurnReport(report churnReport) string {
+	crossNodeReuses := 0
+	for _, reuse := range report.Reuses {
+		if reuse.Node != reuse.PreviousNode {
+			crossNodeReuses++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "pods churned : %d\n", report.Churned)
+	fmt.Fprintf(&sb, "IP reuse events : %d (%d on another node)\n", len(report.Reuses), crossNodeReuses)
+	for _, reuse := range report.Reuses {
+		fmt.Fprintf(&sb, "  %s : %s(%s) => %s(%s)\n", reuse.IP, reuse.PreviousPod, reuse.PreviousNode, reuse.Pod, reuse.Node)
+	}
+	fmt.Fprintf(&sb, "misdirected responses : %d\n", len(report.Misdirected))
+	for _, response := range report.Misdirected {
+		fmt.Fprintf(&sb, "  node %s => %s answered by '%s' instead of %s\n", response.ProberNode, response.IP, response.AnsweredBy, response.ExpectedPod)
+	}
+	fmt.Fprintf(&sb, "blackholed : %d\n", len(report.Blackholed))
+	for _, blackholed := range report.Blackholed {
+		fmt.Fprintf(&sb, "  %s\n", blackholed)
+	}
+
+	return sb.String()
+}
+
+// probeChurnedPod requests the hostname the pod serves on ProbePodPort from every prober until the pod answers,
+// adding every response which came from another pod and every node the pod never answered to the report.
+func probeChurnedPod(probers []corev1.Pod, pod *corev1.Pod, podIP string, report *churnReport) {
+	url := fmt.Sprintf("http://%s:%d/", podIP, ProbePodPort)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, prober := range probers {
+		wg.Add(1)
+		go func(prober corev1.Pod) {
+			defer wg.Done()
+			defer GinkgoRecover()
+
+			answeredBy := map[string]bool{}
+			expectation := eventuallyReachable(timeouts.Probing, timeouts.ProbeInterval)
+			outcome := evaluateExpectation(expectation, func() bool {
+				response, err := getHTTPResponseFromPod(prober.Name, prober.Namespace, url, clientset, config)
+				if err != nil {
+					return false
+				}
+				hostname := strings.TrimSpace(response)
+				if hostname == pod.Name {
+					return true
+				}
+				if !answeredBy[hostname] {
+					answeredBy[hostname] = true
+					mutex.Lock()
+					report.Misdirected = append(report.Misdirected, misdirectedResponse{
+						ProberNode: prober.Spec.NodeName, IP: podIP, ExpectedPod: pod.Name, AnsweredBy: hostname,
+					})
+					mutex.Unlock()
+					glog.Errorf("node %s => %s is answered by '%s' instead of %s\n", prober.Spec.NodeName, podIP, hostname, pod.Name)
+				}
+				return false
+			})
+
+			result := applyProbeOutcome(makeProbeResult(ChurnCase, &prober, podIP, pod.Spec.NodeName, ProbeKindPod), expectation, outcome)
+			if len(answeredBy) > 0 {
+				result.Success = false
+				result.Message = fmt.Sprintf("misdirected to %s", strings.Join(getSortedKeys(answeredBy), ", "))
+			}
+			recordProbeResult(result)
+
+			if !outcome.Met {
+				mutex.Lock()
+				report.Blackholed = append(report.Blackholed, fmt.Sprintf("node %s => %s (%s)", prober.Spec.NodeName, podIP, pod.Name))
+				mutex.Unlock()
+			}
+		}(prober)
+	}
+	wg.Wait()
+}
+
+// checkPodChurn deletes and recreates pods round robin over the nodes, and checks that every pod IP, reused ones in
+// particular, is answered by the pod which currently holds it from every node.
+func checkPodChurn() {
+	if len(nodes.Items) < 2 {
+		Skip("pod churn needs at least 2 nodes")
+	}
+
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	probers, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	report := churnReport{}
+	owners := map[string]ipReuse{}
+	noGracePeriod := int64(0)
+	for i := 0; i < *churnIterations; i++ {
+		nodeName := nodes.Items[i%len(nodes.Items)].Name
+		pod, err := createPodInSpecificNode(clientset, ChurnPodPrefix, nodeName, testingNamespace.Name)
+		Expect(err).ToNot(HaveOccurred())
+		err = waitTimeoutForPodStatus(clientset, pod.Name, pod.Namespace, corev1.PodRunning, timeouts.Provisioning)
+		Expect(err).ToNot(HaveOccurred())
+		podIP, err := getPodIP(clientset, pod.Name, pod.Namespace)
+		Expect(err).ToNot(HaveOccurred())
+
+		if owner, ok := owners[podIP]; ok {
+			reuse := ipReuse{IP: podIP, PreviousPod: owner.Pod, PreviousNode: owner.Node, Pod: pod.Name, Node: nodeName}
+			report.Reuses = append(report.Reuses, reuse)
+			glog.Infof("IP %s of pod %s in node %s is reused by pod %s in node %s\n", podIP, owner.Pod, owner.Node, pod.Name, nodeName)
+		}
+		owners[podIP] = ipReuse{Pod: pod.Name, Node: nodeName}
+		report.Churned++
+
+		probeChurnedPod(probers.Items, pod, podIP, &report)
+
+		err = clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{GracePeriodSeconds: &noGracePeriod})
+		Expect(err).ToNot(HaveOccurred())
+		err = waitTimeoutForPodDeleted(clientset, pod.Name, pod.Namespace, timeouts.Teardown)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	glog.Infof("========== pod churn ==========\n%s", formatChurnReport(report))
+	Expect(report.Misdirected).To(BeEmpty(), "responses to pod IPs came from other pods")
+	Expect(report.Blackholed).To(BeEmpty(), "pod IPs were not answered")
+}
+
+func getSortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
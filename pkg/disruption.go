@@ -0,0 +1,411 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	DisruptionCase = "N"
+	MirrorPodKey   = "kubernetes.io/config.mirror"
+)
+
+// cniDaemonsetNames are the names of the DaemonSets of well known CNI plugins in kube-system.
+var cniDaemonsetNames = []string{
+	"calico-node", "cilium", "kube-flannel-ds", "canal", "weave-net", "aws-node", "antrea-agent", "kube-router",
+}
+
+// skipUnlessDisruptionIsConfirmed skips disruptive cases unless -confirm-disruptive names the API server of the
+// cluster, so that a cluster is never disrupted by accident.
+func skipUnlessDisruptionIsConfirmed() {
+	if !isDisruptionConfirmed() {
+		Skip(fmt.Sprintf("disruptive cases run only with -confirm-disruptive=%s", config.Host))
+	}
+}
+
+// isDisruptionConfirmed tells whether -confirm-disruptive names the API server of the cluster. Before the client is
+// created, e.g. with -print-rbac, the API server is read from the kubeconfig.
+func isDisruptionConfirmed() bool {
+	if *confirmDisruptive == "" {
+		return false
+	}
+	if config != nil {
+		return *confirmDisruptive == config.Host
+	}
+	restConfig, err := getRestConfig(*kubeContext)
+
+	return err == nil && *confirmDisruptive == restConfig.Host
+}
+
+// getCNIDaemonset returns the DaemonSet given by -cni-daemonset, or the first one of a well known CNI plugin.
+func getCNIDaemonset() (*appsv1.DaemonSet, error) {
+	if *cniDaemonset != "" {
+		parts := strings.SplitN(*cniDaemonset, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("-cni-daemonset must be <namespace>/<name>")
+		}
+		return clientset.AppsV1().DaemonSets(parts[0]).Get(parts[1], metav1.GetOptions{})
+	}
+
+	for _, name := range cniDaemonsetNames {
+		dms, err := clientset.AppsV1().DaemonSets(metav1.NamespaceSystem).Get(name, metav1.GetOptions{})
+		if err == nil {
+			return dms, nil
+		}
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("no DaemonSet of a well known CNI plugin is found in %s", metav1.NamespaceSystem)
+}
+
+// flowSample is a single probe of a flow.
+type flowSample struct {
+	at      time.Time
+	success bool
+}
+
+// flow is a source pod continuously probing a target pod on another node.
+type flow struct {
+	source  corev1.Pod
+	target  corev1.Pod
+	mutex   sync.Mutex
+	samples []flowSample
+}
+
+func (f *flow) String() string {
+	return fmt.Sprintf("%s => %s", f.source.Spec.NodeName, f.target.Spec.NodeName)
+}
+
+func (f *flow) getSamples() []flowSample {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]flowSample{}, f.samples...)
+}
+
+// hasSucceededSince tells whether a probe of the flow started at or after since succeeded.
+func (f *flow) hasSucceededSince(since time.Time) bool {
+	for _, sample := range f.getSamples() {
+		if sample.success && !sample.at.Before(since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// makeFlows pairs every pod with the pod on the next node, so that every node is source and target of one flow.
+func makeFlows(pods []corev1.Pod) []*flow {
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Spec.NodeName < pods[j].Spec.NodeName })
+	var flows []*flow
+	for i := range pods {
+		flows = append(flows, &flow{source: pods[i], target: pods[(i+1)%len(pods)]})
+	}
+
+	return flows
+}
+
+// startFlows probes every flow every probe interval until the returned function is called, which may be called
+// more than once.
+func startFlows(flows []*flow) func() {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, f := range flows {
+		wg.Add(1)
+		go func(f *flow) {
+			defer wg.Done()
+			for {
+				at := time.Now()
+				success := isPossibleToPingFromPodToIP(f.source.Name, f.source.Namespace, f.target.Status.PodIP, clientset, config)
+				f.mutex.Lock()
+				f.samples = append(f.samples, flowSample{at: at, success: success})
+				f.mutex.Unlock()
+
+				select {
+				case <-stop:
+					return
+				case <-time.After(timeouts.ProbeInterval):
+				}
+			}
+		}(f)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+// getDowntime returns the longest and the total time the flow was down from since on, measured from the first
+// failed probe to the next successful one, and whether the flow was up again at its last probe.
+func getDowntime(samples []flowSample, since time.Time) (time.Duration, time.Duration, bool) {
+	var longest, total time.Duration
+	var downSince *time.Time
+	recovered := true
+	for i := range samples {
+		sample := samples[i]
+		if sample.at.Before(since) {
+			continue
+		}
+		if !sample.success && downSince == nil {
+			downSince = &samples[i].at
+		}
+		if sample.success && downSince != nil {
+			outage := sample.at.Sub(*downSince)
+			total += outage
+			if outage > longest {
+				longest = outage
+			}
+			downSince = nil
+		}
+	}
+	if downSince != nil && len(samples) > 0 {
+		outage := samples[len(samples)-1].at.Sub(*downSince)
+		total += outage
+		if outage > longest {
+			longest = outage
+		}
+		recovered = false
+	}
+
+	return longest, total, recovered
+}
+
+// waitTimeoutForFlows waits until every flow succeeded at least once at or after since.
+func waitTimeoutForFlows(flows []*flow, since time.Time, timeout time.Duration) error {
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		for _, f := range flows {
+			if !f.hasSucceededSince(since) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		var down []string
+		for _, f := range flows {
+			if !f.hasSucceededSince(since) {
+				down = append(down, f.String())
+			}
+		}
+		return fmt.Errorf("flows %s are not up within %v", strings.Join(down, ", "), timeout)
+	}
+
+	return nil
+}
+
+// reportDowntime logs the downtime of every flow from since on, records it as ProbeResults, and returns the flows
+// which were down longer than the budget or did not recover.
+func reportDowntime(flows []*flow, since time.Time, budget time.Duration) []string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "flow\tprobes\tfailures\tlongest outage\ttotal downtime\trecovered")
+
+	var violations []string
+	for _, f := range flows {
+		var probes, failures int
+		samples := f.getSamples()
+		for _, sample := range samples {
+			if !sample.at.Before(since) {
+				probes++
+				if !sample.success {
+					failures++
+				}
+			}
+		}
+		longest, total, recovered := getDowntime(samples, since)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%t\n", f, probes, failures, longest.Round(time.Millisecond), total.Round(time.Millisecond), recovered)
+
+		result := makeProbeResult(DisruptionCase, &f.source, f.target.Status.PodIP, f.target.Spec.NodeName, ProbeKindPod)
+		result.Attempts = probes
+		result.Successes = probes - failures
+		result.Classification = classify(probes, probes-failures)
+		result.Success = recovered && longest <= budget
+		if !result.Success {
+			result.Message = fmt.Sprintf("down for %s at most, recovered %t, budget %s", longest.Round(time.Millisecond), recovered, budget)
+			violations = append(violations, fmt.Sprintf("%s (%s)", f, result.Message))
+		}
+		recordProbeResult(result)
+	}
+	w.Flush()
+	glog.Infof("========== downtime per flow ==========\n%s", sb.String())
+
+	return violations
+}
+
+// checkConnectivityDuringDisruption keeps probing pods on different nodes while disrupt runs, and checks that every
+// flow recovers within -recovery-budget.
+func checkConnectivityDuringDisruption(disrupt func()) {
+	if len(nodes.Items) < 2 {
+		Skip("disruption needs at least 2 nodes")
+	}
+
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	flows := makeFlows(podList.Items)
+	stopFlows := startFlows(flows)
+	defer stopFlows()
+
+	err = waitTimeoutForFlows(flows, time.Time{}, timeouts.Probing)
+	Expect(err).ToNot(HaveOccurred(), "flows must be up before the disruption")
+
+	disruptedAt := time.Now()
+	disrupt()
+	disruptionEnd := time.Now()
+	glog.Infof("disruption took %s\n", disruptionEnd.Sub(disruptedAt).Round(time.Second))
+
+	err = waitTimeoutForFlows(flows, disruptionEnd, *recoveryBudget)
+	if err != nil {
+		glog.Error(err)
+	}
+	stopFlows()
+
+	violations := reportDowntime(flows, disruptedAt, *recoveryBudget)
+	Expect(violations).To(BeEmpty(), "flows were down longer than -recovery-budget or did not recover")
+}
+
+// waitTimeoutForDaemonsetPodReplaced waits until the DaemonSet has a ready pod on the node other than oldPodName.
+func waitTimeoutForDaemonsetPodReplaced(dms *appsv1.DaemonSet, nodeName string, oldPodName string, timeout time.Duration) error {
+	selector := metav1.FormatLabelSelector(dms.Spec.Selector)
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		podList, err := getPodsWithLabel(clientset, selector, dms.Namespace)
+		if err != nil {
+			return false, nil
+		}
+		for _, pod := range podList.Items {
+			if pod.Spec.NodeName == nodeName && pod.Name != oldPodName && pod.DeletionTimestamp == nil && isPodReady(&pod) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return infrastructureError(fmt.Errorf("DaemonSet %s has no ready pod on node %s within %v", dms.Name, nodeName, timeout))
+	}
+
+	return nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// restartCNIPods deletes the pods of the CNI DaemonSet one node at a time, waiting for each replacement to be ready.
+func restartCNIPods() {
+	dms, err := getCNIDaemonset()
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, metav1.FormatLabelSelector(dms.Spec.Selector), dms.Namespace)
+	Expect(err).ToNot(HaveOccurred())
+	sort.Slice(podList.Items, func(i, j int) bool { return podList.Items[i].Spec.NodeName < podList.Items[j].Spec.NodeName })
+
+	for _, pod := range podList.Items {
+		glog.Infof("restarting CNI pod %s/%s in node %s\n", pod.Namespace, pod.Name, pod.Spec.NodeName)
+		err = clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		err = waitTimeoutForDaemonsetPodReplaced(dms, pod.Spec.NodeName, pod.Name, timeouts.Provisioning)
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
+
+func setNodeUnschedulable(nodeName string, unschedulable bool) error {
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	node.Spec.Unschedulable = unschedulable
+	_, err = clientset.CoreV1().Nodes().Update(node)
+
+	return err
+}
+
+// isEvictable tells whether drain evicts the pod, which is not the case for DaemonSet pods, mirror pods and
+// pods which are already finished.
+func isEvictable(pod *corev1.Pod) bool {
+	if _, ok := pod.Annotations[MirrorPodKey]; ok {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getEvictablePods(nodeName string) ([]corev1.Pod, error) {
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{FieldSelector: "spec.nodeName=" + nodeName})
+	if err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if isEvictable(&pod) {
+			pods = append(pods, pod)
+		}
+	}
+
+	return pods, nil
+}
+
+// drainNode cordons the node and evicts every pod drain would evict, retrying evictions refused by
+// PodDisruptionBudgets, until the node is empty. The node is uncordoned by the caller.
+func drainNode(nodeName string) {
+	glog.Infof("cordoning node %s\n", nodeName)
+	err := setNodeUnschedulable(nodeName, true)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = wait.PollImmediate(timeouts.PollingInterval, timeouts.Teardown, func() (bool, error) {
+		pods, err := getEvictablePods(nodeName)
+		if err != nil {
+			return false, nil
+		}
+		for _, pod := range pods {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			glog.Infof("evicting pod %s/%s from node %s\n", pod.Namespace, pod.Name, nodeName)
+			err := clientset.CoreV1().Pods(pod.Namespace).Evict(&policyv1beta1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			if err != nil && !errors.IsNotFound(err) {
+				glog.Infof("eviction of pod %s/%s is refused: %v\n", pod.Namespace, pod.Name, err)
+			}
+		}
+		return len(pods) == 0, nil
+	})
+	Expect(infrastructureError(err)).ToNot(HaveOccurred(), "node %s is not drained within %v", nodeName, timeouts.Teardown)
+}
@@ -0,0 +1,522 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DNSBenchmarkCase = "Q"
+
+	RcodeNoError  = "NOERROR"
+	RcodeNoData   = "NODATA"
+	RcodeNXDomain = "NXDOMAIN"
+	RcodeServFail = "SERVFAIL"
+	RcodeRefused  = "REFUSED"
+	RcodeTimeout  = "TIMEOUT"
+	RcodeOther    = "OTHER"
+)
+
+var dnsQueryTypes = []string{"A", "AAAA"}
+
+// ShellNowFunction defines 'now' in a shell script, which prints the current time in nanoseconds with the nanoseconds
+// of date when busybox supports them, and with the centiseconds of /proc/uptime otherwise. With centiseconds it
+// prints CoarseClockMarker, as latencies of a few milliseconds cannot be measured then.
+const ShellNowFunction = "now() { date +%s%N; }; " +
+	"case $(date +%N) in ''|*[!0-9]*) now() { awk '{ printf \"%d0000000\\n\", $1 * 100 }' /proc/uptime; }; " +
+	"echo " + CoarseClockMarker + ";; esac; "
+
+const CoarseClockMarker = "clock-centiseconds"
+
+// hasCoarseClock tells whether the output of a script with ShellNowFunction measured time in centiseconds.
+func hasCoarseClock(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == CoarseClockMarker {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvConf is what the resolver of a pod is configured with in /etc/resolv.conf.
+type resolvConf struct {
+	Nameservers []string
+	Search      []string
+	Ndots       int
+}
+
+func parseResolvConf(content string) resolvConf {
+	conf := resolvConf{Ndots: 1}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		case "search":
+			conf.Search = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				if strings.HasPrefix(option, "ndots:") {
+					if ndots, err := strconv.Atoi(strings.TrimPrefix(option, "ndots:")); err == nil {
+						conf.Ndots = ndots
+					}
+				}
+			}
+		}
+	}
+
+	return conf
+}
+
+func getResolvConfOfPod(pod *corev1.Pod) (resolvConf, error) {
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, []string{"cat", "/etc/resolv.conf"}, clientset, config)
+	if err != nil {
+		return resolvConf{}, fmt.Errorf("failed to read /etc/resolv.conf of pod %s: %v %s", pod.Name, err, stderr)
+	}
+
+	return parseResolvConf(stdout), nil
+}
+
+// expandSearchNames returns the names the resolver of a pod queries in order for name, until one is not NXDOMAIN.
+// Names with fewer dots than ndots are tried with every search domain before as they are, like glibc and musl do.
+func expandSearchNames(name string, conf resolvConf) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	var names []string
+	for _, domain := range conf.Search {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if strings.Count(name, ".") >= conf.Ndots {
+		return append([]string{name + "."}, names...)
+	}
+
+	return append(names, name+".")
+}
+
+// dnsResolver is a DNS server queried by the benchmark.
+type dnsResolver struct {
+	Name string
+	IP   string
+	// Group identifies the resolver across runs, replicas of the service are not told apart
+	Group string
+}
+
+// getDNSResolvers returns the nameserver of the pod when it is not the cluster DNS service, e.g. a NodeLocal
+// DNSCache, the service, and every endpoint of the service, so that a single overloaded replica stands out.
+func getDNSResolvers(conf resolvConf, dnsService *corev1.Service, endpoints *corev1.Endpoints) []dnsResolver {
+	var resolvers []dnsResolver
+	if len(conf.Nameservers) > 0 && conf.Nameservers[0] != dnsService.Spec.ClusterIP {
+		resolvers = append(resolvers, dnsResolver{Name: "resolv.conf", IP: conf.Nameservers[0], Group: "resolv.conf"})
+	}
+	resolvers = append(resolvers, dnsResolver{Name: dnsService.Name, IP: dnsService.Spec.ClusterIP, Group: dnsService.Name})
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			name := address.IP
+			if address.TargetRef != nil {
+				name = address.TargetRef.Name
+			}
+			resolvers = append(resolvers, dnsResolver{Name: name, IP: address.IP, Group: dnsService.Name + "-endpoint"})
+		}
+	}
+
+	return resolvers
+}
+
+// getDNSService returns the service and the endpoints of the cluster DNS given by -dns-service.
+func getDNSService() (*corev1.Service, *corev1.Endpoints, error) {
+	parts := strings.SplitN(*dnsServiceName, "/", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("-dns-service must be <namespace>/<name>")
+	}
+	svc, err := clientset.CoreV1().Services(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	endpoints, err := clientset.CoreV1().Endpoints(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return svc, endpoints, nil
+}
+
+// makeDNSBenchmarkScript returns a script which looks up every name and query type lookups times from server, and
+// prints "q <name> <type> <microseconds> <queries>" and the output of the last query for every lookup. busybox nslookup
+// does not expand names with the search domains, so the names the resolver of the pod would query are queried in
+// order until one is not NXDOMAIN.
+func makeDNSBenchmarkScript(server string, names []string, conf resolvConf, lookups int) string {
+	var sb strings.Builder
+	sb.WriteString(ShellNowFunction)
+	fmt.Fprintf(&sb, "q() { name=$1; t=$2; shift 2; s=$(now); n=0; "+
+		"for c in \"$@\"; do n=$((n+1)); out=$(nslookup -type=$t $c %s 2>&1); case \"$out\" in *NXDOMAIN*) ;; *) break;; esac; done; "+
+		"echo \"q $name $t $(( ($(now) - s) / 1000 )) $n\" $out; }; ", server)
+	for _, name := range names {
+		for _, queryType := range dnsQueryTypes {
+			fmt.Fprintf(&sb, "i=0; while [ $i -lt %d ]; do q %s %s %s; i=$((i+1)); done; ", lookups, name, queryType,
+				strings.Join(expandSearchNames(name, conf), " "))
+		}
+	}
+
+	return sb.String()
+}
+
+// categorizeDNSResponse maps the output of busybox nslookup to a response code, or TIMEOUT when nothing answered.
+func categorizeDNSResponse(output string) string {
+	switch {
+	case strings.Contains(output, "timed out"), strings.Contains(output, "no servers could be reached"):
+		return RcodeTimeout
+	case strings.Contains(output, "SERVFAIL"):
+		return RcodeServFail
+	case strings.Contains(output, "REFUSED"):
+		return RcodeRefused
+	case strings.Contains(output, "NXDOMAIN"):
+		return RcodeNXDomain
+	case strings.Contains(output, "No answer"):
+		return RcodeNoData
+	case strings.Contains(output, "Name:"), strings.Contains(output, "origin ="):
+		return RcodeNoError
+	}
+
+	return RcodeOther
+}
+
+// dnsResult is what a probe pod observed looking up a name with a query type from a resolver.
+type dnsResult struct {
+	Node          string
+	Resolver      string
+	ResolverGroup string
+	Name          string
+	Type          string
+	Lookups       int
+	Queries       int
+	Latencies     []float64
+	Rcodes        map[string]int
+}
+
+// failures are lookups which were not answered with a record, NODATA or NXDOMAIN.
+func (r dnsResult) failures() int {
+	return r.Lookups - r.Rcodes[RcodeNoError] - r.Rcodes[RcodeNoData] - r.Rcodes[RcodeNXDomain]
+}
+
+func (r dnsResult) failureRate() float64 {
+	if r.Lookups == 0 {
+		return 0
+	}
+
+	return float64(r.failures()) / float64(r.Lookups)
+}
+
+func (r *dnsResult) add(other dnsResult) {
+	r.Lookups += other.Lookups
+	r.Queries += other.Queries
+	r.Latencies = append(r.Latencies, other.Latencies...)
+	for rcode, count := range other.Rcodes {
+		r.Rcodes[rcode] += count
+	}
+}
+
+// parseDNSBenchmarkOutput returns a result per name and query type in the order they were looked up.
+func parseDNSBenchmarkOutput(output string) []dnsResult {
+	var results []dnsResult
+	index := map[string]int{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "q" {
+			continue
+		}
+		key := fields[1] + " " + fields[2]
+		if _, ok := index[key]; !ok {
+			index[key] = len(results)
+			results = append(results, dnsResult{Name: fields[1], Type: fields[2], Rcodes: map[string]int{}})
+		}
+		result := &results[index[key]]
+		result.Lookups++
+		if queries, err := strconv.Atoi(fields[4]); err == nil {
+			result.Queries += queries
+		}
+		if micros, err := strconv.ParseFloat(fields[3], 64); err == nil {
+			result.Latencies = append(result.Latencies, micros/1000)
+		}
+		result.Rcodes[categorizeDNSResponse(strings.Join(fields[5:], " "))]++
+	}
+
+	return results
+}
+
+// formatDNSResults writes a row per node, resolver, name and query type, followed by totals per resolver and per node.
+func formatDNSResults(results []dnsResult) string {
+	totals := map[string]*dnsResult{}
+	var keys []string
+	addTotal := func(key string, total dnsResult, result dnsResult) {
+		if _, ok := totals[key]; !ok {
+			total.Rcodes = map[string]int{}
+			totals[key] = &total
+			keys = append(keys, key)
+		}
+		totals[key].add(result)
+	}
+	for _, result := range results {
+		addTotal("resolver "+result.Resolver, dnsResult{Node: "total", Resolver: result.Resolver, Name: "*", Type: "*"}, result)
+	}
+	for _, result := range results {
+		addTotal("node "+result.Node, dnsResult{Node: result.Node, Resolver: "total", Name: "*", Type: "*"}, result)
+	}
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tRESOLVER\tNAME\tTYPE\tLOOKUPS\tQUERIES/LOOKUP\tP50(ms)\tP99(ms)\tFAILURES\tRESPONSES")
+	write := func(result dnsResult) {
+		queriesPerLookup := 0.0
+		if result.Lookups > 0 {
+			queriesPerLookup = float64(result.Queries) / float64(result.Lookups)
+		}
+		latencies := "-\t-"
+		if len(result.Latencies) > 0 {
+			latencies = fmt.Sprintf("%.1f\t%.1f", percentile(result.Latencies, 50), percentile(result.Latencies, 99))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%.1f\t%s\t%.2f%%\t%s\n", result.Node, result.Resolver, result.Name,
+			result.Type, result.Lookups, queriesPerLookup, latencies, result.failureRate()*100, formatCounts(result.Rcodes))
+	}
+	for _, result := range results {
+		write(result)
+	}
+	for _, key := range keys {
+		write(*totals[key])
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+func benchmarkResolver(pod corev1.Pod, resolver dnsResolver, names []string, conf resolvConf) []dnsResult {
+	glog.Infof("looking up %v %d times from pod %s in node %s with %s(%s)\n", names, *dnsLookups, pod.Name,
+		pod.Spec.NodeName, resolver.Name, resolver.IP)
+	script := makeDNSBenchmarkScript(resolver.IP, names, conf, *dnsLookups)
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, []string{"sh", "-c", script}, clientset, config)
+	if err != nil {
+		glog.Errorf("DNS benchmark in pod %s failed: %v %s\n", pod.Name, err, stderr)
+	}
+	results := parseDNSBenchmarkOutput(stdout)
+	coarseClock := hasCoarseClock(stdout)
+	if coarseClock {
+		glog.Warningf("date of pod %s has no nanoseconds, DNS latencies in node %s are not reported\n", pod.Name,
+			pod.Spec.NodeName)
+	}
+	for i := range results {
+		if coarseClock {
+			results[i].Latencies = nil
+		}
+		results[i].Node = pod.Spec.NodeName
+		results[i].Resolver = resolver.Name
+		results[i].ResolverGroup = resolver.Group
+	}
+
+	return results
+}
+
+// dnsThroughput is how many queries a probe pod completed with concurrent lookups from a resolver for a while.
+type dnsThroughput struct {
+	Node        string
+	Resolver    string
+	Concurrency int
+	Queries     int
+	Timeouts    int
+	Seconds     float64
+}
+
+// queriesPerSecond is the rate of answered queries, timed out ones are not counted.
+func (t dnsThroughput) queriesPerSecond() float64 {
+	if t.Seconds <= 0 {
+		return 0
+	}
+
+	return float64(t.Queries-t.Timeouts) / t.Seconds
+}
+
+// makeDNSThroughputScript returns a script which runs concurrency loops looking up the names from server one after
+// another for duration, and prints "t ok" or "t timeout" per query and "elapsed <microseconds>" at the end.
+func makeDNSThroughputScript(server string, names []string, concurrency int, duration time.Duration) string {
+	return ShellNowFunction + fmt.Sprintf("s=$(now); end=$(( $(date +%%s) + %d )); "+
+		"w() { while [ $(date +%%s) -lt $end ]; do for c in %s; do out=$(nslookup $c %s 2>&1); "+
+		"case \"$out\" in *\"timed out\"*|*\"no servers could be reached\"*) echo \"t timeout\";; *) echo \"t ok\";; esac; "+
+		"done; done; }; i=0; while [ $i -lt %d ]; do w & i=$((i+1)); done; wait; echo \"elapsed $(( ($(now) - s) / 1000 ))\"",
+		int(duration.Seconds()), strings.Join(names, " "), server, concurrency)
+}
+
+// parseDNSThroughputOutput counts the queries printed by the throughput script.
+func parseDNSThroughputOutput(output string) dnsThroughput {
+	throughput := dnsThroughput{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "t":
+			throughput.Queries++
+			if fields[1] == "timeout" {
+				throughput.Timeouts++
+			}
+		case len(fields) == 2 && fields[0] == "elapsed":
+			if micros, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				throughput.Seconds = micros / 1000000
+			}
+		}
+	}
+
+	return throughput
+}
+
+func measureDNSThroughput(pod corev1.Pod, resolver dnsResolver, names []string, conf resolvConf) dnsThroughput {
+	var queried []string
+	for _, name := range names {
+		queried = append(queried, expandSearchNames(name, conf)...)
+	}
+	glog.Infof("looking up %v with %d concurrent loops for %s from pod %s in node %s with %s(%s)\n", queried, *dnsConcurrency,
+		*dnsThroughputDuration, pod.Name, pod.Spec.NodeName, resolver.Name, resolver.IP)
+	script := makeDNSThroughputScript(resolver.IP, queried, *dnsConcurrency, *dnsThroughputDuration)
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, []string{"sh", "-c", script}, clientset, config)
+	if err != nil {
+		glog.Errorf("DNS throughput in pod %s failed: %v %s\n", pod.Name, err, stderr)
+	}
+	throughput := parseDNSThroughputOutput(stdout)
+	throughput.Node = pod.Spec.NodeName
+	throughput.Resolver = resolver.Name
+	throughput.Concurrency = *dnsConcurrency
+
+	return throughput
+}
+
+// formatDNSThroughputs writes a row per node and resolver, followed by totals per resolver.
+func formatDNSThroughputs(throughputs []dnsThroughput) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tRESOLVER\tCONCURRENCY\tQUERIES\tTIMEOUTS\tSECONDS\tQPS")
+	totals := map[string]float64{}
+	var resolvers []string
+	for _, throughput := range throughputs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%.1f\t%.1f\n", throughput.Node, throughput.Resolver, throughput.Concurrency,
+			throughput.Queries, throughput.Timeouts, throughput.Seconds, throughput.queriesPerSecond())
+		if _, ok := totals[throughput.Resolver]; !ok {
+			resolvers = append(resolvers, throughput.Resolver)
+		}
+		totals[throughput.Resolver] += throughput.queriesPerSecond()
+	}
+	for _, resolver := range resolvers {
+		fmt.Fprintf(w, "total\t%s\t-\t-\t-\t-\t%.1f\n", resolver, totals[resolver])
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// getProbePodsByNode creates the probe DaemonSet and returns its pods sorted by node.
+func getProbePodsByNode() []corev1.Pod {
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	pods := podList.Items
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Spec.NodeName < pods[j].Spec.NodeName })
+
+	return pods
+}
+
+// checkDNSPerformance looks up the names of -dns-names with A and AAAA queries from the probe pod of every node, with
+// the nameserver of the pod, the cluster DNS service and every replica behind it, and reports latency, failures and
+// the queries search expansion costs per node and per resolver. Afterwards the throughput of concurrent lookups is
+// measured from every node at the same time, so that latency is measured without load.
+func checkDNSPerformance() {
+	dnsService, endpoints, err := getDNSService()
+	Expect(err).ToNot(HaveOccurred())
+
+	pods := getProbePodsByNode()
+	names := splitFilter(*dnsNames)
+	resultsPerPod := make([][]dnsResult, len(pods))
+	throughputsPerPod := make([][]dnsThroughput, len(pods))
+	confs := make([]*resolvConf, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conf, err := getResolvConfOfPod(&pods[i])
+			if err != nil {
+				glog.Errorf("%v\n", err)
+				return
+			}
+			confs[i] = &conf
+			// resolvers are queried one after another, so that a pod does not compete with itself
+			for _, resolver := range getDNSResolvers(conf, dnsService, endpoints) {
+				resultsPerPod[i] = append(resultsPerPod[i], benchmarkResolver(pods[i], resolver, names, conf)...)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range pods {
+		if confs[i] == nil || *dnsConcurrency <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, resolver := range getDNSResolvers(*confs[i], dnsService, endpoints) {
+				throughputsPerPod[i] = append(throughputsPerPod[i], measureDNSThroughput(pods[i], resolver, names, *confs[i]))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var results []dnsResult
+	var throughputs []dnsThroughput
+	for i := range pods {
+		results = append(results, resultsPerPod[i]...)
+		throughputs = append(throughputs, throughputsPerPod[i]...)
+	}
+	glog.Infof("========== DNS performance ==========\n%s", formatDNSResults(results))
+	if len(throughputs) > 0 {
+		glog.Infof("========== DNS throughput ==========\n%s", formatDNSThroughputs(throughputs))
+	}
+
+	var failed []string
+	for i, podResults := range resultsPerPod {
+		if len(podResults) == 0 {
+			failed = append(failed, fmt.Sprintf("%s (no lookups)", pods[i].Spec.NodeName))
+		}
+		for _, result := range podResults {
+			probeResult := makeNamedProbeResult(DNSBenchmarkCase, &pods[i], fmt.Sprintf("%s %s @%s", result.Name, result.Type,
+				result.Resolver), fmt.Sprintf("%s %s @%s", result.Name, result.Type, result.ResolverGroup), ProbeKindDNS)
+			probeResult.Expectation = fmt.Sprintf("failure rate <= %.3f", *dnsMaxFailureRate)
+			probeResult.Attempts = result.Lookups
+			probeResult.Successes = result.Lookups - result.failures()
+			probeResult.Classification = classify(probeResult.Attempts, probeResult.Successes)
+			probeResult.Success = result.Lookups > 0 && result.failureRate() <= *dnsMaxFailureRate
+			if len(result.Latencies) > 0 {
+				probeResult.LatencyMillis = percentile(result.Latencies, 50)
+			}
+			if !probeResult.Success {
+				probeResult.Message = fmt.Sprintf("%d/%d lookups failed, responses %s", result.failures(), result.Lookups,
+					formatCounts(result.Rcodes))
+				failed = append(failed, fmt.Sprintf("%s => %s (%s)", result.Node, probeResult.Target, probeResult.Message))
+			}
+			recordProbeResult(probeResult)
+		}
+	}
+	Expect(failed).To(BeEmpty(), "DNS failure rate is above -dns-max-failure-rate")
+}
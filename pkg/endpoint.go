@@ -0,0 +1,252 @@
+package sntt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	endpointSliceServiceNameLabel = "kubernetes.io/service-name"
+
+	// PropagationBatchRequests is the number of requests in a batch of measurePropagation.
+	PropagationBatchRequests = 5
+	// RemovalConfirmations is the number of consecutive batches which must not reach a removed endpoint. With two
+	// endpoints, a single batch misses an endpoint which is still routed to once in 2^5 times, three batches once in
+	// 2^15 times.
+	RemovalConfirmations = 3
+)
+
+// propagationResult is the time it took until the probe pod on a node saw an endpoint change.
+type propagationResult struct {
+	nodeName string
+	latency  time.Duration
+	err      error
+}
+
+// getReadyEndpointSlicePods returns the names of the pods which are ready endpoints in the EndpointSlices.
+func getReadyEndpointSlicePods(slices map[string]*unstructured.Unstructured) map[string]bool {
+	readyPods := map[string]bool{}
+	for _, slice := range slices {
+		endpoints, _, _ := unstructured.NestedSlice(slice.Object, "endpoints")
+		for _, endpoint := range endpoints {
+			endpointMap, ok := endpoint.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ready, found, _ := unstructured.NestedBool(endpointMap, "conditions", "ready")
+			if found && !ready {
+				continue
+			}
+			podName, _, _ := unstructured.NestedString(endpointMap, "targetRef", "name")
+			if podName != "" {
+				readyPods[podName] = true
+			}
+		}
+	}
+
+	return readyPods
+}
+
+// waitTimeoutForEndpointSlices watches the EndpointSlices of the service until condition is satisfied by the set of
+// ready endpoint pods, and returns the time the satisfying change was observed.
+func waitTimeoutForEndpointSlices(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, svcName string,
+	namespace string, timeout time.Duration, condition func(readyPods map[string]bool) bool) (time.Time, error) {
+	listOptions := metav1.ListOptions{LabelSelector: endpointSliceServiceNameLabel + "=" + svcName}
+	sliceList, err := dynamicClient.Resource(gvr).Namespace(namespace).List(listOptions)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	slices := map[string]*unstructured.Unstructured{}
+	for i := range sliceList.Items {
+		slices[sliceList.Items[i].GetName()] = &sliceList.Items[i]
+	}
+	if condition(getReadyEndpointSlicePods(slices)) {
+		return time.Now(), nil
+	}
+
+	listOptions.ResourceVersion = sliceList.GetResourceVersion()
+	watcher, err := dynamicClient.Resource(gvr).Namespace(namespace).Watch(listOptions)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer watcher.Stop()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return time.Time{}, fmt.Errorf("watch of EndpointSlices for service %s is closed", svcName)
+			}
+			slice, ok := event.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				slices[slice.GetName()] = slice
+			case watch.Deleted:
+				delete(slices, slice.GetName())
+			default:
+				continue
+			}
+			if condition(getReadyEndpointSlicePods(slices)) {
+				return time.Now(), nil
+			}
+		case <-timer.C:
+			return time.Time{}, fmt.Errorf("EndpointSlices for service %s did not change as expected within %v", svcName, timeout)
+		}
+	}
+}
+
+// measurePropagation probes url from every pod concurrently until isPropagated returns true for the hostnames
+// which answered confirmations consecutive batches of requests, and returns the latency measured from since until the
+// first of those batches.
+func measurePropagation(pods []corev1.Pod, url string, since time.Time, timeout time.Duration, confirmations int,
+	isPropagated func(hits map[string]int, failures int) bool) []propagationResult {
+	results := make([]propagationResult, len(pods))
+
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pod := pods[i]
+			results[i].nodeName = pod.Spec.NodeName
+			confirmed := 0
+			var latency time.Duration
+			for time.Since(since) < timeout {
+				hits, failures, err := getHostnameHitsFromPod(pod.Name, pod.Namespace, url, PropagationBatchRequests)
+				if err != nil || !isPropagated(hits, failures) {
+					confirmed = 0
+					time.Sleep(timeouts.ProbeInterval)
+					continue
+				}
+				if confirmed == 0 {
+					latency = time.Since(since)
+				}
+				confirmed++
+				if confirmed >= confirmations {
+					results[i].latency = latency
+					return
+				}
+			}
+			results[i].err = fmt.Errorf("endpoint change is not propagated to node %s within %v", pod.Spec.NodeName, timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func reportPropagation(title string, results []propagationResult) {
+	glog.Infof("========== %s ==========\n", title)
+	for _, result := range results {
+		if result.err != nil {
+			glog.Infof("%s : %v\n", result.nodeName, result.err)
+			continue
+		}
+		glog.Infof("%s : %v\n", result.nodeName, result.latency)
+	}
+}
+
+// checkEndpointPropagation scales an echo server deployment behind a ClusterIP service and measures how long it takes
+// until the probe pod on every node routes traffic to a new endpoint, and stops routing traffic to a removed one.
+func checkEndpointPropagation() {
+	gvr, found := getServedGroupVersionResource("discovery.k8s.io", []string{"v1", "v1beta1"}, "endpointslices")
+	if !found {
+		Skip("EndpointSlice API is not served by the cluster")
+	}
+
+	echoLabels := map[string]string{"sntt": "echo"}
+	deploy, err := createEchoServerDeployment(clientset, "echo-", testingNamespace.Name, echoLabels, 1)
+	Expect(err).ToNot(HaveOccurred())
+	svc, err := createService(clientset, "echo-", testingNamespace.Name, echoLabels, EchoServerPort, corev1.ServiceTypeClusterIP)
+	Expect(err).ToNot(HaveOccurred())
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = waitTimeoutForDeploymentReady(clientset, deploy.Name, deploy.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	echoPodList, err := getPodsWithLabel(clientset, "sntt=echo", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	initialPods := map[string]bool{}
+	for _, echoPod := range echoPodList.Items {
+		initialPods[echoPod.Name] = true
+	}
+
+	url := fmt.Sprintf("http://%s:%d/hostname", svc.Spec.ClusterIP, EchoServerPort)
+
+	// endpoint 추가
+	err = scaleDeployment(clientset, deploy.Name, deploy.Namespace, 2)
+	Expect(err).ToNot(HaveOccurred())
+	var addedPod string
+	addedAt, err := waitTimeoutForEndpointSlices(dynamicClient, gvr, svc.Name, svc.Namespace, timeouts.Probing, func(readyPods map[string]bool) bool {
+		for podName := range readyPods {
+			if !initialPods[podName] {
+				addedPod = podName
+				return true
+			}
+		}
+		return false
+	})
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("endpoint %s is added to the EndpointSlices\n", addedPod)
+
+	addResults := measurePropagation(podList.Items, url, addedAt, timeouts.Probing, 1, func(hits map[string]int, failures int) bool {
+		return hits[addedPod] > 0
+	})
+	reportPropagation("endpoint addition propagation latency per node", addResults)
+
+	// endpoint 제거
+	err = scaleDeployment(clientset, deploy.Name, deploy.Namespace, 1)
+	Expect(err).ToNot(HaveOccurred())
+	var removedPod string
+	removedAt, err := waitTimeoutForEndpointSlices(dynamicClient, gvr, svc.Name, svc.Namespace, timeouts.Probing, func(readyPods map[string]bool) bool {
+		if len(readyPods) != 1 {
+			return false
+		}
+		for podName := range initialPods {
+			if !readyPods[podName] {
+				removedPod = podName
+				return true
+			}
+		}
+		if !readyPods[addedPod] {
+			removedPod = addedPod
+			return true
+		}
+		return false
+	})
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("endpoint %s is removed from the EndpointSlices\n", removedPod)
+
+	removeResults := measurePropagation(podList.Items, url, removedAt, timeouts.Probing, RemovalConfirmations, func(hits map[string]int, failures int) bool {
+		return hits[removedPod] == 0 && failures == 0
+	})
+	reportPropagation("endpoint removal propagation latency per node", removeResults)
+
+	for _, result := range append(addResults, removeResults...) {
+		Expect(result.err).ToNot(HaveOccurred())
+	}
+}
@@ -0,0 +1,252 @@
+package sntt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ExpectationKind is how the outcomes of repeated probes between the same pair are judged.
+type ExpectationKind string
+
+const (
+	ExpectEventuallyReachable     ExpectationKind = "eventually-reachable"
+	ExpectConsistentlyReachable   ExpectationKind = "consistently-reachable"
+	ExpectConsistentlyUnreachable ExpectationKind = "consistently-unreachable"
+	ExpectSuccessRate             ExpectationKind = "success-rate"
+)
+
+const (
+	ClassificationReachable   = "reachable"
+	ClassificationUnreachable = "unreachable"
+	ClassificationFlaky       = "flaky"
+)
+
+// Expectation describes what a pair is expected to look like over repeated probes.
+type Expectation struct {
+	Kind ExpectationKind
+	// Timeout bounds eventually-reachable probes.
+	Timeout time.Duration
+	// Duration is how long consistently-reachable and consistently-unreachable pairs are observed.
+	Duration time.Duration
+	// Attempts and MinSuccessRate are the number of probes and the share of them which must succeed for success-rate.
+	Attempts       int
+	MinSuccessRate float64
+	// Interval is the pause between two probes.
+	Interval time.Duration
+	// WarmUp, when set, retries probes until the first success for up to WarmUp before the expectation is evaluated,
+	// so that pods which just started are not reported as flaky.
+	WarmUp time.Duration
+}
+
+func eventuallyReachable(timeout time.Duration, interval time.Duration) Expectation {
+	return Expectation{Kind: ExpectEventuallyReachable, Timeout: timeout, Interval: interval}
+}
+
+func consistentlyReachable(duration time.Duration, interval time.Duration) Expectation {
+	return Expectation{Kind: ExpectConsistentlyReachable, Duration: duration, Interval: interval}
+}
+
+func consistentlyUnreachable(duration time.Duration, interval time.Duration) Expectation {
+	return Expectation{Kind: ExpectConsistentlyUnreachable, Duration: duration, Interval: interval}
+}
+
+func successRate(attempts int, minSuccessRate float64, interval time.Duration) Expectation {
+	return Expectation{Kind: ExpectSuccessRate, Attempts: attempts, MinSuccessRate: minSuccessRate, Interval: interval}
+}
+
+func (e Expectation) String() string {
+	switch e.Kind {
+	case ExpectEventuallyReachable:
+		return fmt.Sprintf("%s within %s", e.Kind, e.Timeout)
+	case ExpectConsistentlyReachable, ExpectConsistentlyUnreachable:
+		return fmt.Sprintf("%s for %s", e.Kind, e.Duration)
+	case ExpectSuccessRate:
+		return fmt.Sprintf("%s >= %.0f%% of %d", e.Kind, e.MinSuccessRate*100, e.Attempts)
+	}
+
+	return string(e.Kind)
+}
+
+// getReachableExpectation returns the expectation for pairs which must be reachable, chosen by -probe-expectation.
+func getReachableExpectation() Expectation {
+	var expectation Expectation
+	switch *probeExpectation {
+	case string(ExpectConsistentlyReachable):
+		expectation = consistentlyReachable(*probeDuration, timeouts.ProbeInterval)
+	case string(ExpectSuccessRate):
+		expectation = successRate(*probeAttempts, *probeMinSuccessRate, timeouts.ProbeInterval)
+	default:
+		return eventuallyReachable(timeouts.Probing, timeouts.PollingInterval)
+	}
+	expectation.WarmUp = timeouts.Probing
+
+	return expectation
+}
+
+func validateProbeExpectationFlags() error {
+	switch *probeExpectation {
+	case string(ExpectEventuallyReachable), string(ExpectConsistentlyReachable), string(ExpectSuccessRate):
+	default:
+		return fmt.Errorf("unknown -probe-expectation '%s'", *probeExpectation)
+	}
+	if *probeAttempts < 1 {
+		return fmt.Errorf("-probe-attempts must be positive")
+	}
+	if *probeMinSuccessRate < 0 || *probeMinSuccessRate > 1 {
+		return fmt.Errorf("-probe-min-success-rate must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// ProbeOutcome is the result of evaluating an expectation.
+type ProbeOutcome struct {
+	Attempts       int
+	Successes      int
+	Classification string
+	Met            bool
+}
+
+func classify(attempts int, successes int) string {
+	switch successes {
+	case 0:
+		return ClassificationUnreachable
+	case attempts:
+		return ClassificationReachable
+	}
+
+	return ClassificationFlaky
+}
+
+// evaluateExpectation probes repeatedly as the expectation requires and classifies what was observed.
+// Failures before the first success of an eventually-reachable pair are not counted as flakiness.
+func evaluateExpectation(expectation Expectation, probe func() bool) ProbeOutcome {
+	if expectation.WarmUp > 0 {
+		warmUp := evaluateExpectation(eventuallyReachable(expectation.WarmUp, expectation.Interval), probe)
+		if !warmUp.Met {
+			warmUp.Met = expectation.Kind == ExpectConsistentlyUnreachable
+			return warmUp
+		}
+	}
+
+	var outcome ProbeOutcome
+	attempt := func() bool {
+		outcome.Attempts++
+		if probe() {
+			outcome.Successes++
+			return true
+		}
+		return false
+	}
+
+	switch expectation.Kind {
+	case ExpectEventuallyReachable:
+		deadline := time.Now().Add(expectation.Timeout)
+		for !attempt() && time.Now().Before(deadline) {
+			time.Sleep(expectation.Interval)
+		}
+		outcome.Met = outcome.Successes > 0
+		outcome.Classification = ClassificationUnreachable
+		if outcome.Met {
+			outcome.Classification = ClassificationReachable
+		}
+		return outcome
+	case ExpectConsistentlyReachable, ExpectConsistentlyUnreachable:
+		deadline := time.Now().Add(expectation.Duration)
+		for {
+			attempt()
+			if !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(expectation.Interval)
+		}
+	case ExpectSuccessRate:
+		for i := 0; i < expectation.Attempts; i++ {
+			if i > 0 {
+				time.Sleep(expectation.Interval)
+			}
+			attempt()
+		}
+	}
+
+	outcome.Classification = classify(outcome.Attempts, outcome.Successes)
+	switch expectation.Kind {
+	case ExpectConsistentlyReachable:
+		outcome.Met = outcome.Classification == ClassificationReachable
+	case ExpectConsistentlyUnreachable:
+		outcome.Met = outcome.Classification == ClassificationUnreachable
+	case ExpectSuccessRate:
+		outcome.Met = float64(outcome.Successes) >= expectation.MinSuccessRate*float64(outcome.Attempts)
+	}
+
+	return outcome
+}
+
+// makeProbeResult returns a ProbeResult from the pod to the target. The pod is looked up again when it was not
+// scheduled yet at the time it was created.
+func makeProbeResult(caseName string, pod *corev1.Pod, target string, targetNode string, kind string) ProbeResult {
+	nodeName := pod.Spec.NodeName
+	if nodeName == "" {
+		if scheduledPod, err := clientset.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{}); err == nil {
+			nodeName = scheduledPod.Spec.NodeName
+		}
+	}
+
+	return ProbeResult{
+		Case:       caseName,
+		SourceNode: nodeName,
+		SourcePod:  pod.Name,
+		TargetNode: targetNode,
+		Target:     target,
+		Kind:       kind,
+	}
+}
+
+// makeNamedProbeResult returns a ProbeResult from the pod to a target which is not on a node, e.g. a service or an
+// external host, identified across runs by name instead of by its address.
+func makeNamedProbeResult(caseName string, pod *corev1.Pod, target string, targetName string, kind string) ProbeResult {
+	result := makeProbeResult(caseName, pod, target, "", kind)
+	result.TargetName = targetName
+
+	return result
+}
+
+// applyProbeOutcome fills the classification of the outcome into the result.
+func applyProbeOutcome(result ProbeResult, expectation Expectation, outcome ProbeOutcome) ProbeResult {
+	result.Expectation = expectation.String()
+	result.Classification = outcome.Classification
+	result.Attempts = outcome.Attempts
+	result.Successes = outcome.Successes
+	result.Success = outcome.Met
+	if !outcome.Met {
+		result.Message = fmt.Sprintf("%s, expected %s (%d/%d succeeded)", outcome.Classification, expectation, outcome.Successes, outcome.Attempts)
+	}
+
+	return result
+}
+
+// evaluateProbe evaluates the expectation with probe and records the classified result without failing the test, for
+// cases which report every result before failing.
+func evaluateProbe(result ProbeResult, expectation Expectation, probe func() bool) ProbeResult {
+	outcome := evaluateExpectation(expectation, probe)
+	result = applyProbeOutcome(result, expectation, outcome)
+	recordProbeResult(result)
+	glog.Infof("%s => %s is %s (%d/%d succeeded), expected %s\n", result.SourcePod, result.Target, outcome.Classification,
+		outcome.Successes, outcome.Attempts, expectation)
+
+	return result
+}
+
+// expectProbe evaluates the expectation with probe, records the classified result and fails the test when the
+// expectation is not met.
+func expectProbe(result ProbeResult, expectation Expectation, probe func() bool) {
+	result = evaluateProbe(result, expectation, probe)
+
+	Expect(result.Success).To(BeTrue(), "%s => %s is %s, expected %s (%d/%d succeeded)", result.SourcePod, result.Target,
+		result.Classification, result.Expectation, result.Successes, result.Attempts)
+}
@@ -0,0 +1,177 @@
+package sntt
+
+import (
+	"testing"
+	"time"
+)
+
+// makeTestProbe returns a probe which returns results in order, and the last one after them.
+func makeTestProbe(results ...bool) func() bool {
+	i := 0
+	return func() bool {
+		result := results[len(results)-1]
+		if i < len(results) {
+			result = results[i]
+		}
+		i++
+		return result
+	}
+}
+
+func repeatTestResult(result bool, count int) []bool {
+	results := make([]bool, count)
+	for i := range results {
+		results[i] = result
+	}
+
+	return results
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		attempts       int
+		successes      int
+		classification string
+	}{
+		{0, 0, ClassificationUnreachable},
+		{5, 0, ClassificationUnreachable},
+		{5, 5, ClassificationReachable},
+		{5, 2, ClassificationFlaky},
+		{1, 1, ClassificationReachable},
+	}
+
+	for _, test := range tests {
+		if classification := classify(test.attempts, test.successes); classification != test.classification {
+			t.Errorf("%d of %d attempts are classified %s, expected %s", test.successes, test.attempts, classification,
+				test.classification)
+		}
+	}
+}
+
+func TestEvaluateExpectation(t *testing.T) {
+	interval := time.Millisecond
+	withWarmUp := func(expectation Expectation) Expectation {
+		expectation.WarmUp = 20 * time.Millisecond
+		return expectation
+	}
+
+	tests := []struct {
+		name           string
+		expectation    Expectation
+		results        []bool
+		met            bool
+		classification string
+		attempts       int
+	}{
+		{
+			name:           "eventually reachable after failures",
+			expectation:    eventuallyReachable(time.Second, interval),
+			results:        []bool{false, false, true},
+			met:            true,
+			classification: ClassificationReachable,
+			attempts:       3,
+		},
+		{
+			name:           "eventually reachable times out",
+			expectation:    eventuallyReachable(20*time.Millisecond, interval),
+			results:        []bool{false},
+			met:            false,
+			classification: ClassificationUnreachable,
+		},
+		{
+			name:           "consistently reachable",
+			expectation:    consistentlyReachable(10*time.Millisecond, interval),
+			results:        []bool{true},
+			met:            true,
+			classification: ClassificationReachable,
+		},
+		{
+			name:           "consistently reachable with a drop is flaky",
+			expectation:    consistentlyReachable(10*time.Millisecond, interval),
+			results:        []bool{true, false, true},
+			met:            false,
+			classification: ClassificationFlaky,
+		},
+		{
+			name:           "consistently unreachable",
+			expectation:    consistentlyUnreachable(10*time.Millisecond, interval),
+			results:        []bool{false},
+			met:            true,
+			classification: ClassificationUnreachable,
+		},
+		{
+			name:           "consistently unreachable with a leak",
+			expectation:    consistentlyUnreachable(10*time.Millisecond, interval),
+			results:        []bool{false, true, false},
+			met:            false,
+			classification: ClassificationFlaky,
+		},
+		{
+			name:           "success rate at the threshold",
+			expectation:    successRate(10, 0.8, interval),
+			results:        append(repeatTestResult(true, 8), false, false),
+			met:            true,
+			classification: ClassificationFlaky,
+			attempts:       10,
+		},
+		{
+			name:           "success rate below the threshold",
+			expectation:    successRate(10, 0.8, interval),
+			results:        append(repeatTestResult(true, 7), false, false, false),
+			met:            false,
+			classification: ClassificationFlaky,
+			attempts:       10,
+		},
+		{
+			name:           "success rate of every attempt",
+			expectation:    successRate(5, 1, interval),
+			results:        []bool{true},
+			met:            true,
+			classification: ClassificationReachable,
+			attempts:       5,
+		},
+		{
+			name:           "failures during warm-up are not flakiness",
+			expectation:    withWarmUp(consistentlyReachable(10*time.Millisecond, interval)),
+			results:        []bool{false, false, true},
+			met:            true,
+			classification: ClassificationReachable,
+		},
+		{
+			name:           "failures during warm-up do not count for the success rate",
+			expectation:    withWarmUp(successRate(4, 1, interval)),
+			results:        []bool{false, false, true},
+			met:            true,
+			classification: ClassificationReachable,
+			attempts:       4,
+		},
+		{
+			name:           "success rate is not met without a success during warm-up",
+			expectation:    withWarmUp(successRate(4, 0.5, interval)),
+			results:        []bool{false},
+			met:            false,
+			classification: ClassificationUnreachable,
+		},
+		{
+			name:           "consistently unreachable is met without a success during warm-up",
+			expectation:    withWarmUp(consistentlyUnreachable(10*time.Millisecond, interval)),
+			results:        []bool{false},
+			met:            true,
+			classification: ClassificationUnreachable,
+		},
+	}
+
+	for _, test := range tests {
+		outcome := evaluateExpectation(test.expectation, makeTestProbe(test.results...))
+		if outcome.Met != test.met || outcome.Classification != test.classification {
+			t.Errorf("%s: met %v classified %s, expected met %v classified %s", test.name, outcome.Met,
+				outcome.Classification, test.met, test.classification)
+		}
+		if test.attempts > 0 && outcome.Attempts != test.attempts {
+			t.Errorf("%s: %d attempts, expected %d", test.name, outcome.Attempts, test.attempts)
+		}
+		if outcome.Successes > outcome.Attempts {
+			t.Errorf("%s: %d successes of %d attempts", test.name, outcome.Successes, outcome.Attempts)
+		}
+	}
+}
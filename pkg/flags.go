@@ -0,0 +1,176 @@
+package sntt
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SNATPolicyAuto     = "auto"
+	SNATPolicyNode     = "node"
+	SNATPolicyEgressIP = "egress-ip"
+	SNATPolicyNone     = "none"
+)
+
+// stringSliceFlag is a flag which can be given several times.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+var (
+	impersonateGroups stringSliceFlag
+	caseTimeoutFlags  stringSliceFlag
+)
+
+func init() {
+	flag.Var(&impersonateGroups, "as-group", "group to impersonate for the operation, can be repeated")
+
+	// timeouts are read through getCaseTimeouts, which layers them with the test plan and -case-timeout
+	flag.Duration(phaseFlags[PhaseProvisioning], ProvisioningTimeout,
+		"how long to wait for pods, DaemonSets, Deployments, endpoints and load balancers to become ready")
+	flag.Duration(phaseFlags[PhaseProbing], Timeout, "how long probes are retried until a pair becomes reachable")
+	flag.Duration(phaseFlags[PhaseTeardown], Timeout, "how long to wait for namespaces, pods and DaemonSets to be deleted")
+	flag.Duration(phaseFlags[PhasePollingInterval], PollingInterval, "pause between retries of probing and teardown conditions")
+	flag.Duration(phaseFlags[PhaseProbeInterval], pollIntervalToPing, "pause between repeated probes of a pair and between provisioning polls")
+	flag.Var(&caseTimeoutFlags, "case-timeout",
+		"timeout of a single case as '<case>.<phase>=<duration>', e.g. 'F-3.provisioning=10m', can be repeated. "+
+			"Phases are 'provisioning', 'probing', 'teardown', 'polling-interval' and 'probe-interval'")
+}
+
+var (
+	kubeconfig      = flag.String("kubeconfig", getKubeconfigPathFromEnv(), "absolute path to the kubeconfig file")
+	kubeContext     = flag.String("context", "", "kubeconfig context to use. The current context is used when empty")
+	impersonateUser = flag.String("as", "", "username to impersonate for the operation")
+	kubeAPIQPS      = flag.Float64("kube-api-qps", 20, "maximum QPS to the kubernetes API server")
+	kubeAPIBurst    = flag.Int("kube-api-burst", 50, "maximum burst for throttle to the kubernetes API server")
+
+	podSecurityLevel = flag.String("pod-security-level", PodSecurityPrivileged,
+		"Pod Security level the testing namespaces are labelled with, one of 'privileged', 'baseline', 'restricted'. "+
+			"Probe pods meet the level, ping is replaced by TCP probes with 'restricted' and hostNetwork cases are skipped unless 'privileged'")
+
+	probeTolerations = flag.String("probe-tolerations", "",
+		"comma separated tolerations of probe pods: '*' tolerates every taint, or 'key', 'key=value', 'key:Effect', 'key=value:Effect'")
+	probeNodeAffinity = flag.String("probe-node-affinity", "",
+		"comma separated required node affinity of probe pods: 'key=v1|v2', 'key!=v1|v2', 'key' or '!key'")
+	probePriorityClass = flag.String("probe-priority-class", "", "priorityClassName of probe pods")
+	probeCPURequest    = flag.String("probe-cpu-request", "", "CPU request of probe pods, e.g. '10m'")
+	probeMemoryRequest = flag.String("probe-memory-request", "", "memory request of probe pods, e.g. '16Mi'")
+	probeCPULimit      = flag.String("probe-cpu-limit", "", "CPU limit of probe pods")
+	probeMemoryLimit   = flag.String("probe-memory-limit", "", "memory limit of probe pods")
+
+	testPlanFile = flag.String("test-plan", "",
+		"YAML or JSON file with the case filters, the fail-fast mode and timeouts of the run and of single cases")
+	includeCases = flag.String("include", "",
+		"comma separated tags or case IDs to run, e.g. 'pod-to-pod,F-1'. Every case is run when empty. "+
+			"Tags are 'pod-to-pod', 'cross-node', 'egress', 'dns', 'policy', 'service', 'slow' and 'disruptive'")
+	excludeCases = flag.String("exclude", "", "comma separated tags or case IDs not to run, e.g. 'slow,disruptive'")
+	failFast     = flag.Bool("fail-fast", false,
+		"skip every remaining case after the first infrastructure error, e.g. a pod which is not running in time, instead of waiting out their timeouts")
+
+	probeExpectation = flag.String("probe-expectation", string(ExpectEventuallyReachable),
+		"how pairs which must be reachable are judged: 'eventually-reachable', 'consistently-reachable' for -probe-duration "+
+			"or 'success-rate' of at least -probe-min-success-rate over -probe-attempts. Failing pairs are classified as unreachable or flaky")
+	probeDuration       = flag.Duration("probe-duration", 30*time.Second, "how long pairs are probed with -probe-expectation=consistently-reachable")
+	probeAttempts       = flag.Int("probe-attempts", 10, "number of probes per pair with -probe-expectation=success-rate")
+	probeMinSuccessRate = flag.Float64("probe-min-success-rate", 0.9, "share of probes which must succeed with -probe-expectation=success-rate")
+
+	resultsFile = flag.String("results-file", "", "write the structured results of the run to this JSON file. It can be used as a baseline later")
+	htmlReport  = flag.String("html-report", "", "write a self-contained HTML report with a node-by-node connectivity heatmap to this file")
+	compareMode = flag.Bool("compare", false,
+		"compare -results-file with -baseline-file instead of running tests, and exit with a nonzero code on regression")
+	baselineFile               = flag.String("baseline-file", "", "results file of an earlier run to compare with")
+	compareOutput              = flag.String("compare-output", "", "write the differences found by -compare to this JSON file")
+	latencyRegressionThreshold = flag.Float64("latency-regression-threshold", 0.5,
+		"latency increase relative to the baseline reported as regression by -compare, e.g. 0.5 for +50%")
+	latencyRegressionMinMillis = flag.Float64("latency-regression-min-ms", 1,
+		"latency increases smaller than this are never reported as regression by -compare")
+
+	printRBAC     = flag.Bool("print-rbac", false, "print the ClusterRole and Roles needed by the selected tests and exit")
+	skipPreflight = flag.Bool("skip-preflight", false, "do not check permissions of the current identity before running tests")
+
+	snatWhoamiURL = flag.String("snat-whoami-url", "",
+		"URL of an endpoint outside the cluster which answers with the client address it observed (e.g. agnhost netexec '/clientip'). "+
+			"A tool-managed echo server pod is used as a stand-in when empty")
+	snatPolicy = flag.String("snat-policy", SNATPolicyAuto,
+		"expected source IP seen by the whoami endpoint: 'node', 'egress-ip', 'none' or 'auto' ('none' for the stand-in, the address a hostNetwork pod on the same node is seen with otherwise)")
+	snatEgressIP = flag.String("snat-egress-ip", "", "fixed egress IP expected with -snat-policy=egress-ip")
+
+	testLoadBalancer = flag.Bool("test-loadbalancer", false,
+		"also test LoadBalancer services. The cluster must be able to provision load balancers")
+
+	serviceReplicas      = flag.Int("service-replicas", 3, "number of echo server replicas behind the service in load distribution tests")
+	serviceRequests      = flag.Int("service-requests", 60, "number of requests sent from each probe pod in load distribution tests")
+	distributionMinRatio = flag.Float64("distribution-min-ratio", 0.5,
+		"minimum share of an even distribution each endpoint must receive before the distribution is reported as skewed")
+
+	measureEndpointPropagation = flag.Bool("measure-endpoint-propagation", false,
+		"measure how long endpoint additions and removals take to reach every node")
+
+	ingressControllerService = flag.String("ingress-controller-service", "",
+		"<namespace>/<name> of the ingress controller service. Well known ingress controllers are detected when empty")
+	ingressClass      = flag.String("ingress-class", "", "ingress class of the Ingress created by the ingress test")
+	ingressProbeLocal = flag.Bool("ingress-probe-local", false,
+		"also send requests to the ingress controller's load balancer from the machine running sntt")
+
+	clusterContexts = flag.String("cluster-contexts", "",
+		"comma separated kubeconfig contexts of the clusters to test connectivity across. Multi-cluster cases are skipped when empty")
+	exportedServiceDomain = flag.String("exported-service-domain", "",
+		"domain of exported multi-cluster services, e.g. 'clusterset.local'. Exported service names are not tested when empty")
+
+	topologyKey           = flag.String("topology-key", "topology.kubernetes.io/zone", "node label to group nodes by in the topology test, e.g. 'topology.kubernetes.io/region'")
+	topologyNodesPerGroup = flag.Int("topology-nodes-per-group", 0, "maximum number of nodes of each topology group to probe between, every node when 0")
+	topologyPingCount     = flag.Int("topology-ping-count", 5, "number of pings between every pair of pods in the topology test")
+
+	confirmDisruptive = flag.String("confirm-disruptive", "",
+		"run the disruptive cases, which restart the CNI pods and drain a node, against the cluster whose API server URL is given. "+
+			"Disruptive cases are skipped when empty or when the URL does not match")
+	cniDaemonset = flag.String("cni-daemonset", "",
+		"<namespace>/<name> of the CNI DaemonSet restarted by the disruptive case. Well known CNI plugins in kube-system are detected when empty")
+	drainNodeName  = flag.String("drain-node", "", "node cordoned and drained by the disruptive case. Draining is skipped when empty")
+	recoveryBudget = flag.Duration("recovery-budget", time.Minute, "longest time a flow between pods may be down during a disruption")
+
+	connectionDuration = flag.Duration("connection-duration", 0,
+		"how long TCP connections through the service are held while the service is changed in the long-lived connection test, e.g. 2m. The test is skipped when 0")
+	heartbeatInterval = flag.Duration("heartbeat-interval", time.Second, "interval of heartbeats over long-lived connections, in whole seconds")
+
+	stressRate = flag.Int("stress-rate", 0,
+		"short-lived connections opened per second from every node to each target in the connection-rate stress test. The test is skipped when 0")
+	stressDuration       = flag.Duration("stress-duration", 30*time.Second, "how long connections are opened in the connection-rate stress test, in whole seconds")
+	stressMinSuccessRate = flag.Float64("stress-min-success-rate", 0.99, "lowest success rate of short-lived connections from a node to a target")
+
+	dnsServiceName = flag.String("dns-service", "kube-system/kube-dns", "<namespace>/<name> of the cluster DNS service benchmarked with every replica behind it")
+	dnsNames       = flag.String("dns-names", "kubernetes.default,kubernetes.default.svc.cluster.local.,google.com,google.com.",
+		"comma separated names looked up by the DNS benchmark. Names without a trailing dot are expanded with the search domains of the pod")
+	dnsLookups        = flag.Int("dns-lookups", 20, "lookups of every name and query type from every node and resolver in the DNS benchmark")
+	dnsMaxFailureRate = flag.Float64("dns-max-failure-rate", 0.01, "highest rate of SERVFAIL, REFUSED and timed out lookups of a name from a node and resolver")
+
+	dnsConcurrency        = flag.Int("dns-concurrency", 10, "concurrent lookups from every node and resolver in the throughput phase of the DNS benchmark, which is skipped when 0")
+	dnsThroughputDuration = flag.Duration("dns-throughput-duration", 10*time.Second,
+		"how long lookups are made concurrently from every node and resolver in the throughput phase of the DNS benchmark")
+
+	expectedResolver = flag.String("expected-resolver", "",
+		"resolver pods are expected to use, 'kube-dns' or 'nodelocal'. NodeLocal DNSCache is expected when empty and its DaemonSet exists")
+	nodeLocalDNSDaemonset = flag.String("nodelocal-dns-daemonset", "kube-system/node-local-dns", "<namespace>/<name> of the NodeLocal DNSCache DaemonSet")
+	nodeLocalDNSIP        = flag.String("nodelocal-dns-ip", "169.254.20.10", "link-local address NodeLocal DNSCache listens on in every node")
+	corednsConfigMap      = flag.String("coredns-configmap", "kube-system/coredns", "<namespace>/<name> of the ConfigMap with the Corefile of CoreDNS")
+	dnsUpstreamName       = flag.String("dns-upstream-name", "google.com.", "name looked up through the upstream forwarders of the root zone of the Corefile")
+
+	policyNamespaces = flag.String("policy-namespaces", "",
+		"comma separated namespaces whose NetworkPolicies are tested with probe pods created in them. The conformance matrix is skipped when empty")
+	policyProbePods = flag.String("policy-probe-pods", "",
+		"semicolon separated '<namespace>/<labels>' of the policy probe pods, e.g. 'prod/app=web,tier=front;prod/'. Derived from the pod selectors of the policies when empty")
+	policyPorts = flag.String("policy-ports", strconv.Itoa(ProbePodPort), "comma separated '[name=]port' every policy probe pod listens on, e.g. 'http=8080,5432'")
+
+	churnIterations = flag.Int("churn-iterations", 10, "number of pods deleted and recreated round robin over the nodes in the pod churn test")
+
+	gatewayClass = flag.String("gateway-class", "", "GatewayClass of the Gateway created by the Gateway API test. The first accepted one is used when empty")
+)
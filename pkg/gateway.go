@@ -0,0 +1,326 @@
+package sntt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	GatewayAPIGroup    = "gateway.networking.k8s.io"
+	GatewayHost        = "gateway.sntt.example.test"
+	GatewayRouteHeader = "x-sntt-route"
+)
+
+var gatewayAPIVersions = []string{"v1", "v1beta1"}
+
+// getGatewayClassName returns the GatewayClass given by -gateway-class, or the first accepted GatewayClass.
+func getGatewayClassName(gatewayClassGVR schema.GroupVersionResource) (string, error) {
+	if *gatewayClass != "" {
+		_, err := dynamicClient.Resource(gatewayClassGVR).Get(*gatewayClass, metav1.GetOptions{})
+		return *gatewayClass, err
+	}
+
+	classList, err := dynamicClient.Resource(gatewayClassGVR).List(metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, class := range classList.Items {
+		conditions, _, _ := unstructured.NestedSlice(class.Object, "status", "conditions")
+		if hasTrueCondition(conditions, "Accepted") {
+			return class.GetName(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no accepted GatewayClass is found")
+}
+
+func hasTrueCondition(conditions []interface{}, conditionType string) bool {
+	for _, condition := range conditions {
+		conditionMap, ok := condition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if conditionMap["type"] == conditionType && conditionMap["status"] == "True" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func makeGatewaySpec(gvr schema.GroupVersionResource, gatewayNamePrefix string, namespace string, className string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       "Gateway",
+			"metadata": map[string]interface{}{
+				"generateName": gatewayNamePrefix,
+				"namespace":    namespace,
+			},
+			"spec": map[string]interface{}{
+				"gatewayClassName": className,
+				"listeners": []interface{}{
+					map[string]interface{}{
+						"name":     "http",
+						"protocol": "HTTP",
+						"port":     int64(80),
+					},
+				},
+			},
+		},
+	}
+}
+
+func makeBackendRef(svcName string, weight int64) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   svcName,
+		"port":   int64(EchoServerPort),
+		"weight": weight,
+	}
+}
+
+// makeHTTPRouteSpec returns an HTTPRoute with four rules:
+// requests with the route header go to the canary service, '/weighted' is split evenly between both services,
+// '/rewrite' goes to the stable service and every other request goes to the stable service as well.
+// Both path prefixes are rewritten to '/' before they reach the echo servers.
+func makeHTTPRouteSpec(gvr schema.GroupVersionResource, routeNamePrefix string, namespace string, gatewayName string,
+	stableSvcName string, canarySvcName string) *unstructured.Unstructured {
+	rules := []interface{}{
+		map[string]interface{}{
+			"matches": []interface{}{
+				map[string]interface{}{
+					"headers": []interface{}{
+						map[string]interface{}{"name": GatewayRouteHeader, "value": "canary"},
+					},
+				},
+			},
+			"backendRefs": []interface{}{makeBackendRef(canarySvcName, 1)},
+		},
+		map[string]interface{}{
+			"matches": []interface{}{
+				map[string]interface{}{
+					"path": map[string]interface{}{"type": "PathPrefix", "value": "/weighted"},
+				},
+			},
+			"filters": []interface{}{
+				map[string]interface{}{
+					"type": "URLRewrite",
+					"urlRewrite": map[string]interface{}{
+						"path": map[string]interface{}{"type": "ReplacePrefixMatch", "replacePrefixMatch": "/"},
+					},
+				},
+			},
+			"backendRefs": []interface{}{makeBackendRef(stableSvcName, 50), makeBackendRef(canarySvcName, 50)},
+		},
+		map[string]interface{}{
+			"matches": []interface{}{
+				map[string]interface{}{
+					"path": map[string]interface{}{"type": "PathPrefix", "value": "/rewrite"},
+				},
+			},
+			"filters": []interface{}{
+				map[string]interface{}{
+					"type": "URLRewrite",
+					"urlRewrite": map[string]interface{}{
+						"path": map[string]interface{}{"type": "ReplacePrefixMatch", "replacePrefixMatch": "/"},
+					},
+				},
+			},
+			"backendRefs": []interface{}{makeBackendRef(stableSvcName, 1)},
+		},
+		map[string]interface{}{
+			"matches": []interface{}{
+				map[string]interface{}{
+					"path": map[string]interface{}{"type": "PathPrefix", "value": "/"},
+				},
+			},
+			"backendRefs": []interface{}{makeBackendRef(stableSvcName, 1)},
+		},
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       "HTTPRoute",
+			"metadata": map[string]interface{}{
+				"generateName": routeNamePrefix,
+				"namespace":    namespace,
+			},
+			"spec": map[string]interface{}{
+				"parentRefs": []interface{}{
+					map[string]interface{}{"name": gatewayName},
+				},
+				"hostnames": []interface{}{GatewayHost},
+				"rules":     rules,
+			},
+		},
+	}
+}
+
+// waitTimeoutForGatewayAddress waits until the Gateway is programmed and returns its first address.
+func waitTimeoutForGatewayAddress(gvr schema.GroupVersionResource, gatewayName string, namespace string,
+	timeout time.Duration) (string, error) {
+	var address string
+
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		gateway, err := dynamicClient.Resource(gvr).Namespace(namespace).Get(gatewayName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+		for _, gatewayAddress := range addresses {
+			addressMap, ok := gatewayAddress.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if value, ok := addressMap["value"].(string); ok && value != "" {
+				address = value
+				return true, nil
+			}
+		}
+		glog.Infof("Gateway %s has no address yet", gatewayName)
+		return false, nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("Gateway %s has no address within %v", gatewayName, timeout)
+	}
+
+	return address, nil
+}
+
+func createEchoServerBackend(namePrefix string, labels map[string]string) (string, []string) {
+	deploy, err := createEchoServerDeployment(clientset, namePrefix, testingNamespace.Name, labels, 1)
+	Expect(err).ToNot(HaveOccurred())
+	svc, err := createService(clientset, namePrefix, testingNamespace.Name, labels, EchoServerPort, corev1.ServiceTypeClusterIP)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = waitTimeoutForDeploymentReady(clientset, deploy.Name, deploy.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	var selector []string
+	for key, value := range labels {
+		selector = append(selector, key+"="+value)
+	}
+	podList, err := getPodsWithLabel(clientset, strings.Join(selector, ","), testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	var podNames []string
+	for _, pod := range podList.Items {
+		podNames = append(podNames, pod.Name)
+	}
+
+	return svc.Name, podNames
+}
+
+// checkGatewayAPI creates a Gateway and an HTTPRoute in front of a stable and a canary echo server and checks
+// header based routing, weighted backends and path rewrites by sending requests from the probe pod on every node.
+func checkGatewayAPI() {
+	gatewayGVR, found := getServedGroupVersionResource(GatewayAPIGroup, gatewayAPIVersions, "gateways")
+	if !found {
+		Skip("Gateway API CRDs are not installed")
+	}
+	routeGVR, found := getServedGroupVersionResource(GatewayAPIGroup, gatewayAPIVersions, "httproutes")
+	if !found {
+		Skip("Gateway API HTTPRoute CRD is not installed")
+	}
+	gatewayClassGVR, found := getServedGroupVersionResource(GatewayAPIGroup, gatewayAPIVersions, "gatewayclasses")
+	if !found {
+		Skip("Gateway API GatewayClass CRD is not installed")
+	}
+	className, err := getGatewayClassName(gatewayClassGVR)
+	if err != nil {
+		Skip(fmt.Sprintf("Gateway API is not tested : %v", err))
+	}
+	glog.Infof("GatewayClass %s is used\n", className)
+
+	stableSvcName, stablePods := createEchoServerBackend("stable-", map[string]string{"sntt": "echo", "track": "stable"})
+	canarySvcName, canaryPods := createEchoServerBackend("canary-", map[string]string{"sntt": "echo", "track": "canary"})
+
+	gateway, err := dynamicClient.Resource(gatewayGVR).Namespace(testingNamespace.Name).Create(
+		makeGatewaySpec(gatewayGVR, "sntt-", testingNamespace.Name, className), metav1.CreateOptions{})
+	Expect(err).ToNot(HaveOccurred())
+	route, err := dynamicClient.Resource(routeGVR).Namespace(testingNamespace.Name).Create(
+		makeHTTPRouteSpec(routeGVR, "sntt-", testingNamespace.Name, gateway.GetName(), stableSvcName, canarySvcName), metav1.CreateOptions{})
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("Gateway %s and HTTPRoute %s are created\n", gateway.GetName(), route.GetName())
+
+	address, err := waitTimeoutForGatewayAddress(gatewayGVR, gateway.GetName(), testingNamespace.Name, timeouts.Provisioning)
+	Expect(infrastructureError(err)).ToNot(HaveOccurred())
+	glog.Infof("Gateway %s has address %s\n", gateway.GetName(), address)
+
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	hostHeader := "Host: " + GatewayHost
+	for _, pod := range podList.Items {
+		glog.Infof("request from pod %s in node %s to Gateway %s\n", pod.Name, pod.Spec.NodeName, address)
+
+		// header 기반 routing
+		Eventually(func() (string, error) {
+			response, err := getHTTPResponseFromPodWithHeaders(pod.Name, pod.Namespace, fmt.Sprintf("http://%s/hostname", address),
+				[]string{hostHeader, GatewayRouteHeader + ": canary"}, clientset, config)
+			return strings.TrimSpace(response), err
+		}, timeouts.Probing, timeouts.PollingInterval).Should(BeElementOf(canaryPods))
+
+		// path rewrite : '/rewrite/hostname' => '/hostname'
+		Eventually(func() (string, error) {
+			response, err := getHTTPResponseFromPodWithHeaders(pod.Name, pod.Namespace, fmt.Sprintf("http://%s/rewrite/hostname", address),
+				[]string{hostHeader}, clientset, config)
+			return strings.TrimSpace(response), err
+		}, timeouts.Probing, timeouts.PollingInterval).Should(BeElementOf(stablePods))
+
+		// header 가 없거나 값이 다른 request 는 catch-all rule 로 stable backend 에 가야 함
+		for _, headers := range [][]string{{hostHeader}, {hostHeader, GatewayRouteHeader + ": stable"}} {
+			hits, failures, err := getHostnameHitsFromPodWithHeaders(pod.Name, pod.Namespace, fmt.Sprintf("http://%s/hostname", address),
+				headers, *serviceRequests)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failures).To(BeZero(), "requests with headers %v failed", headers)
+			for hostname := range hits {
+				Expect(stablePods).To(ContainElement(hostname), "request with headers %v is not routed to stable", headers)
+			}
+		}
+
+		// weighted backend : 두 backend 모두 응답해야 함
+		hits, failures, err := getHostnameHitsFromPodWithHeaders(pod.Name, pod.Namespace,
+			fmt.Sprintf("http://%s/weighted/hostname", address), []string{hostHeader}, *serviceRequests)
+		Expect(err).ToNot(HaveOccurred())
+		stableHits, canaryHits := 0, 0
+		for hostname, count := range hits {
+			if containsString(stablePods, hostname) {
+				stableHits += count
+			} else if containsString(canaryPods, hostname) {
+				canaryHits += count
+			}
+		}
+		glog.Infof("weighted backends from pod %s : stable=%d, canary=%d, failed=%d\n", pod.Name, stableHits, canaryHits,
+			failures)
+		Expect(checkLoadDistribution(map[string]int{"stable": stableHits, "canary": canaryHits},
+			[]string{"stable", "canary"}, *distributionMinRatio)).To(Succeed())
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+package sntt
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+)
+
+// heatmapCell is a source node => target node cell of the pod-to-pod heatmap.
+type heatmapCell struct {
+	Probes        int
+	Failures      int
+	LatencyMillis float64
+	Color         template.CSS
+	Diagnostics   string
+}
+
+type heatmapRow struct {
+	Source string
+	Cells  []*heatmapCell
+}
+
+type diagnostic struct {
+	ID     string
+	Result ProbeResult
+}
+
+type htmlReportData struct {
+	ResultsFile *ResultsFile
+	TargetNodes []string
+	Rows        []heatmapRow
+	Others      map[string][]ProbeResult
+	OtherNodes  []string
+	Diagnostics []diagnostic
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>sntt report {{.ResultsFile.GeneratedAt.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: center; }
+th { background: #f0f0f0; }
+td.cell a { color: #000; text-decoration: none; display: block; }
+td.left { text-align: left; }
+.fail { background: #e74c3c; color: #fff; }
+.ok { background: #2ecc71; }
+pre { white-space: pre-wrap; margin: 0; }
+</style>
+</head>
+<body>
+<h1>Simple Network Testing Tool report</h1>
+<p>generated at {{.ResultsFile.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}, {{len .ResultsFile.Results}} probe results</p>
+
+<h2>pod-to-pod connectivity</h2>
+{{if .Rows}}
+<p>rows are source nodes, columns are target nodes. Cells show failures/probes and the mean latency, red cells link to their diagnostics.</p>
+<table>
+<tr><th>source \ target</th>{{range .TargetNodes}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><th>{{.Source}}</th>{{range .Cells}}{{if .}}<td class="cell" style="background: {{.Color}}">{{if .Diagnostics}}<a href="#{{.Diagnostics}}">{{end}}{{.Failures}}/{{.Probes}}{{if gt .LatencyMillis 0.0}}<br>{{printf "%.3f" .LatencyMillis}}ms{{end}}{{if .Diagnostics}}</a>{{end}}</td>{{else}}<td>-</td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{else}}<p>no pod-to-pod results</p>{{end}}
+
+<h2>external, service and DNS targets per node</h2>
+{{if .OtherNodes}}<table>
+<tr><th>source node</th><th>case</th><th>kind</th><th>target</th><th>result</th></tr>
+{{range $node := .OtherNodes}}{{range index $.Others $node}}<tr><td class="left">{{$node}}</td><td>{{.Case}}</td><td>{{.Kind}}</td><td class="left">{{.Target}}</td>{{if .Success}}<td class="ok">ok</td>{{else}}<td class="fail">{{if .Classification}}{{.Classification}}{{else}}failed{{end}}</td>{{end}}</tr>
+{{end}}{{end}}</table>
+{{else}}<p>no results</p>{{end}}
+
+<h2>nodes not covered by probe pods</h2>
+{{if .ResultsFile.UncoveredNodes}}<table>
+<tr><th>node</th><th>daemonset</th><th>reason</th></tr>
+{{range .ResultsFile.UncoveredNodes}}<tr><td class="left">{{.Node}}</td><td>{{.DaemonSet}}</td><td class="left">{{.Reason}}</td></tr>
+{{end}}</table>
+{{else}}<p>every node is covered</p>{{end}}
+
+<h2>diagnostics</h2>
+{{if .Diagnostics}}<table>
+<tr><th>#</th><th>case</th><th>source</th><th>target</th><th>message</th></tr>
+{{range .Diagnostics}}<tr id="{{.ID}}"><td>{{.ID}}</td><td>{{.Result.Case}}</td><td class="left">{{.Result.SourceCluster}} {{.Result.SourceNode}}<br>{{.Result.SourcePod}}</td><td class="left">{{.Result.TargetCluster}} {{.Result.TargetNode}}<br>{{.Result.Target}}</td><td class="left"><pre>{{.Result.Message}}</pre></td></tr>
+{{end}}</table>
+{{else}}<p>no failed probes</p>{{end}}
+</body>
+</html>
+`))
+
+// getHeatmapColor returns red for cells with failures, and a green to yellow color by latency relative to maxLatency.
+func getHeatmapColor(cell *heatmapCell, maxLatency float64) template.CSS {
+	if cell.Failures > 0 {
+		return "#e74c3c"
+	}
+	ratio := 0.0
+	if maxLatency > 0 {
+		ratio = math.Min(cell.LatencyMillis/maxLatency, 1)
+	}
+
+	return template.CSS(fmt.Sprintf("hsl(%d, 70%%, 60%%)", int(120-60*ratio)))
+}
+
+func makeHTMLReport(resultsFile *ResultsFile) *htmlReportData {
+	report := &htmlReportData{ResultsFile: resultsFile, Others: map[string][]ProbeResult{}}
+
+	cells := map[string]map[string]*heatmapCell{}
+	latencyCounts := map[*heatmapCell]int{}
+	var sources []string
+	for i, result := range resultsFile.Results {
+		source := joinNonEmpty(result.SourceCluster, result.SourceNode)
+		var cell *heatmapCell
+		if result.Kind == ProbeKindPod && result.TargetNode != "" {
+			target := joinNonEmpty(result.TargetCluster, result.TargetNode)
+			if _, ok := cells[source]; !ok {
+				cells[source] = map[string]*heatmapCell{}
+				sources = append(sources, source)
+			}
+			if !containsString(report.TargetNodes, target) {
+				report.TargetNodes = append(report.TargetNodes, target)
+			}
+			cell = cells[source][target]
+			if cell == nil {
+				cell = &heatmapCell{}
+				cells[source][target] = cell
+			}
+			cell.Probes++
+			if result.Success && result.LatencyMillis > 0 {
+				cell.LatencyMillis += result.LatencyMillis
+				latencyCounts[cell]++
+			}
+		} else {
+			if _, ok := report.Others[source]; !ok {
+				report.OtherNodes = append(report.OtherNodes, source)
+			}
+			report.Others[source] = append(report.Others[source], result)
+		}
+
+		if !result.Success {
+			id := fmt.Sprintf("diag-%d", i+1)
+			report.Diagnostics = append(report.Diagnostics, diagnostic{ID: id, Result: result})
+			if cell != nil {
+				cell.Failures++
+				if cell.Diagnostics == "" {
+					cell.Diagnostics = id
+				}
+			}
+		}
+	}
+
+	maxLatency := 0.0
+	for cell, count := range latencyCounts {
+		cell.LatencyMillis /= float64(count)
+		maxLatency = math.Max(maxLatency, cell.LatencyMillis)
+	}
+
+	sort.Strings(sources)
+	sort.Strings(report.TargetNodes)
+	sort.Strings(report.OtherNodes)
+	for _, source := range sources {
+		row := heatmapRow{Source: source}
+		for _, target := range report.TargetNodes {
+			cell := cells[source][target]
+			if cell != nil {
+				cell.Color = getHeatmapColor(cell, maxLatency)
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	return report
+}
+
+// writeHTMLReport writes a self-contained HTML report of the results, without any external assets.
+func writeHTMLReport(path string, resultsFile *ResultsFile) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return htmlReportTemplate.Execute(file, makeHTMLReport(resultsFile))
+}
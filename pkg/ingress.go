@@ -0,0 +1,410 @@
+package sntt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	IngressHost          = "sntt.example.test"
+	IngressUnmatchedHost = "unmatched.sntt.example.test"
+	// IngressUnmatchedPath of IngressHost is not routed by the Ingress
+	IngressUnmatchedPath = "/sntt-unrouted"
+
+	// ingress requests are sent with curl, as busybox wget can neither send SNI for a name it does not resolve nor
+	// verify certificates
+	CurlImage            = "curlimages/curl:8.5.0"
+	IngressClientCAPath  = "/etc/sntt-tls/ca.crt"
+	IngressClientTimeout = 5
+)
+
+// IngressPaths of IngressHost are routed to the echo service.
+var IngressPaths = []string{"/hostname", "/clientip"}
+
+// ingressControllerSelectors are label selectors of the Services of well known ingress controllers.
+var ingressControllerSelectors = []string{
+	"app.kubernetes.io/name=ingress-nginx,app.kubernetes.io/component=controller",
+	"app.kubernetes.io/name=traefik",
+	"app.kubernetes.io/name=haproxy-ingress",
+	"app.kubernetes.io/name=contour",
+}
+
+// getIngressControllerService returns the Service of the ingress controller given by -ingress-controller-service,
+// or the first Service of a well known ingress controller.
+func getIngressControllerService() (*corev1.Service, error) {
+	if *ingressControllerService != "" {
+		parts := strings.SplitN(*ingressControllerService, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("-ingress-controller-service must be <namespace>/<name>")
+		}
+		return clientset.CoreV1().Services(parts[0]).Get(parts[1], metav1.GetOptions{})
+	}
+
+	for _, selector := range ingressControllerSelectors {
+		svcList, err := clientset.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for i := range svcList.Items {
+			if getServicePort(&svcList.Items[i], 80) != nil {
+				return &svcList.Items[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no ingress controller service is found")
+}
+
+func getServicePort(svc *corev1.Service, port int32) *corev1.ServicePort {
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Port == port {
+			return &svc.Spec.Ports[i]
+		}
+	}
+
+	return nil
+}
+
+// generateSelfSignedCertificate returns a PEM encoded certificate and key for host.
+func generateSelfSignedCertificate(host string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"sntt"}},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return certPEM, keyPEM, nil
+}
+
+func makeTLSSecretSpec(secretNamePrefix string, namespace string, certPEM []byte, keyPEM []byte) *corev1.Secret {
+	secretSpec := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: secretNamePrefix,
+			Namespace:    namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+
+	return secretSpec
+}
+
+// makeIngressSpec returns an Ingress routing IngressPaths of host to the service, terminating TLS with the secret.
+// The v1beta1 backend format is used for clusters that do not serve networking.k8s.io/v1 yet.
+func makeIngressSpec(gvr schema.GroupVersionResource, ingressNamePrefix string, namespace string, host string,
+	svcName string, svcPort int64, tlsSecretName string) *unstructured.Unstructured {
+	backend := map[string]interface{}{
+		"service": map[string]interface{}{
+			"name": svcName,
+			"port": map[string]interface{}{"number": svcPort},
+		},
+	}
+	if gvr.Version == "v1beta1" {
+		backend = map[string]interface{}{
+			"serviceName": svcName,
+			"servicePort": svcPort,
+		}
+	}
+	var paths []interface{}
+	for _, ingressPath := range IngressPaths {
+		paths = append(paths, map[string]interface{}{
+			"path":     ingressPath,
+			"pathType": "Prefix",
+			"backend":  backend,
+		})
+	}
+
+	spec := map[string]interface{}{
+		"tls": []interface{}{
+			map[string]interface{}{
+				"hosts":      []interface{}{host},
+				"secretName": tlsSecretName,
+			},
+		},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"host": host,
+				"http": map[string]interface{}{
+					"paths": paths,
+				},
+			},
+		},
+	}
+	metadata := map[string]interface{}{
+		"generateName": ingressNamePrefix,
+		"namespace":    namespace,
+	}
+	if *ingressClass != "" {
+		if gvr.Version == "v1beta1" {
+			metadata["annotations"] = map[string]interface{}{"kubernetes.io/ingress.class": *ingressClass}
+		} else {
+			spec["ingressClassName"] = *ingressClass
+		}
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       "Ingress",
+			"metadata":   metadata,
+			"spec":       spec,
+		},
+	}
+}
+
+// makeIngressClientDaemonsetSpec returns curl pods on every node which trust the certificate of the TLS secret.
+func makeIngressClientDaemonsetSpec(dmsNamePrefix string, namespace string, tlsSecretName string) *appsv1.DaemonSet {
+	dms := makeDaemonsetSpec(dmsNamePrefix, namespace)
+	dms.Spec.Selector.MatchLabels["sntt"] = "ingress-client"
+	dms.Spec.Template.Labels["sntt"] = "ingress-client"
+	dms.Spec.Template.Spec.Containers = []corev1.Container{
+		{
+			Image:           CurlImage,
+			Name:            "curl",
+			Command:         []string{"sh", "-c", "sleep 3600"},
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "tls", MountPath: path.Dir(IngressClientCAPath), ReadOnly: true},
+			},
+		},
+	}
+	dms.Spec.Template.Spec.Volumes = []corev1.Volume{
+		{
+			Name: "tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: tlsSecretName,
+					Items:      []corev1.KeyToPath{{Key: corev1.TLSCertKey, Path: path.Base(IngressClientCAPath)}},
+				},
+			},
+		},
+	}
+
+	return dms
+}
+
+// requestIngressFromPod sends a request for host and path to the ingress controller at address from a pod of the
+// ingress client DaemonSet, and returns the HTTP status and the body. The host is resolved to address, so that https
+// requests send it as SNI, and certificates are verified against the generated one.
+func requestIngressFromPod(pod *corev1.Pod, scheme string, address string, host string, urlPath string) (int, string, error) {
+	port := 80
+	if scheme == "https" {
+		port = 443
+	}
+	command := []string{"curl", "-s", "-S", "--max-time", strconv.Itoa(IngressClientTimeout),
+		"--cacert", IngressClientCAPath, "--resolve", fmt.Sprintf("%s:%d:%s", host, port, address),
+		"-w", "\n%{http_code}", fmt.Sprintf("%s://%s%s", scheme, host, urlPath)}
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, command, clientset, config)
+	if err != nil {
+		return 0, "", fmt.Errorf("curl %s://%s%s from pod %s failed: %v %s", scheme, host, urlPath, pod.Name, err, stderr)
+	}
+
+	separator := strings.LastIndex(stdout, "\n")
+	status, err := strconv.Atoi(strings.TrimSpace(stdout[separator+1:]))
+	if err != nil {
+		return 0, "", fmt.Errorf("unexpected curl output from pod %s : %q", pod.Name, stdout)
+	}
+
+	return status, stdout[:separator], nil
+}
+
+// getLocalIngressAddress returns the address the ingress controller can be reached at from outside the cluster.
+func getLocalIngressAddress(controllerSvc *corev1.Service) (string, error) {
+	for _, ingress := range controllerSvc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, nil
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname, nil
+		}
+	}
+
+	return "", fmt.Errorf("ingress controller service %s has no load balancer ingress", controllerSvc.Name)
+}
+
+// requestIngressFromLocal sends a request for host to the ingress controller at address from the machine running
+// sntt. https requests are verified against the self-signed certificate, which also checks SNI based termination.
+func requestIngressFromLocal(scheme string, address string, host string, certPEM []byte) (int, string, error) {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	httpClient := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: host},
+		},
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/hostname", scheme, address), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Host = host
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(body), err
+}
+
+// checkIngress deploys echo servers behind an Ingress and checks host and path routing, TLS termination by SNI and
+// that requests for unmatched hosts and paths get 404, sending requests to the ingress controller from a curl pod on
+// every node.
+func checkIngress() {
+	gvr, found := getServedGroupVersionResource("networking.k8s.io", []string{"v1", "v1beta1"}, "ingresses")
+	if !found {
+		Skip("Ingress API is not served by the cluster")
+	}
+	controllerSvc, err := getIngressControllerService()
+	if err != nil {
+		Skip(fmt.Sprintf("Ingress is not tested : %v", err))
+	}
+	glog.Infof("ingress controller service is %s/%s (%s)\n", controllerSvc.Namespace, controllerSvc.Name, controllerSvc.Spec.ClusterIP)
+	if getServicePort(controllerSvc, 443) == nil {
+		Skip(fmt.Sprintf("ingress controller service %s/%s exposes no 443 port, TLS termination is not tested",
+			controllerSvc.Namespace, controllerSvc.Name))
+	}
+
+	echoLabels := map[string]string{"sntt": "echo"}
+	deploy, err := createEchoServerDeployment(clientset, "echo-", testingNamespace.Name, echoLabels, 1)
+	Expect(err).ToNot(HaveOccurred())
+	svc, err := createService(clientset, "echo-", testingNamespace.Name, echoLabels, EchoServerPort, corev1.ServiceTypeClusterIP)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPEM, keyPEM, err := generateSelfSignedCertificate(IngressHost)
+	Expect(err).ToNot(HaveOccurred())
+	secret, err := clientset.CoreV1().Secrets(testingNamespace.Name).Create(makeTLSSecretSpec("sntt-tls-", testingNamespace.Name, certPEM, keyPEM))
+	Expect(err).ToNot(HaveOccurred())
+
+	ingress, err := dynamicClient.Resource(gvr).Namespace(testingNamespace.Name).Create(
+		makeIngressSpec(gvr, "echo-", testingNamespace.Name, IngressHost, svc.Name, EchoServerPort, secret.Name), metav1.CreateOptions{})
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("Ingress %s is created for host %s\n", ingress.GetName(), IngressHost)
+
+	dms, err := createDaemonsetObject(clientset, makeIngressClientDaemonsetSpec("ingress-client-", testingNamespace.Name, secret.Name))
+	Expect(infrastructureError(err)).ToNot(HaveOccurred())
+
+	err = waitTimeoutForDeploymentReady(clientset, deploy.Name, deploy.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	echoPodList, err := getPodsWithLabel(clientset, "sntt=echo", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	var echoPodNames []string
+	for _, echoPod := range echoPodList.Items {
+		echoPodNames = append(echoPodNames, echoPod.Name)
+	}
+
+	podList, err := getPodsWithLabel(clientset, "sntt=ingress-client", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	address := controllerSvc.Spec.ClusterIP
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		glog.Infof("request from pod %s in node %s to ingress controller\n", pod.Name, pod.Spec.NodeName)
+
+		// host, path 에 따른 routing 과 SNI 에 따른 TLS termination
+		for _, scheme := range []string{"http", "https"} {
+			Eventually(func() (string, error) {
+				status, body, err := requestIngressFromPod(pod, scheme, address, IngressHost, "/hostname")
+				if err == nil && status != http.StatusOK {
+					err = fmt.Errorf("unexpected status %d", status)
+				}
+				return strings.TrimSpace(body), err
+			}, timeouts.Probing, timeouts.PollingInterval).Should(BeElementOf(echoPodNames), "%s://%s/hostname from node %s",
+				scheme, IngressHost, pod.Spec.NodeName)
+		}
+		status, body, err := requestIngressFromPod(pod, "http", address, IngressHost, "/clientip")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(http.StatusOK), "path /clientip is not routed for pod %s : %s", pod.Name, body)
+
+		// 일치하는 host, path 가 없으면 404
+		status, _, err = requestIngressFromPod(pod, "http", address, IngressUnmatchedHost, "/hostname")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(http.StatusNotFound), "unmatched host is not answered with 404 for pod %s", pod.Name)
+		status, _, err = requestIngressFromPod(pod, "http", address, IngressHost, IngressUnmatchedPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(http.StatusNotFound), "unmatched path is not answered with 404 for pod %s", pod.Name)
+	}
+
+	if !*ingressProbeLocal {
+		return
+	}
+
+	address, err = getLocalIngressAddress(controllerSvc)
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("request from local machine to ingress controller %s\n", address)
+
+	Eventually(func() (string, error) {
+		status, body, err := requestIngressFromLocal("https", address, IngressHost, certPEM)
+		if err == nil && status != http.StatusOK {
+			err = fmt.Errorf("unexpected status %d", status)
+		}
+		return strings.TrimSpace(body), err
+	}, timeouts.Probing, timeouts.PollingInterval).Should(BeElementOf(echoPodNames))
+
+	status, _, err := requestIngressFromLocal("http", address, IngressUnmatchedHost, certPEM)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(status).To(Equal(http.StatusNotFound))
+}
@@ -0,0 +1,256 @@
+package sntt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	LongLivedCase = "O"
+	TCPEchoPort   = 9000
+
+	ConnectionSurvived = "survived"
+	ConnectionReset    = "reset"
+	ConnectionStalled  = "stalled"
+)
+
+// makeTCPEchoDeploymentSpec returns busybox backends which greet every TCP connection on TCPEchoPort with their
+// hostname and echo everything sent afterwards. 'nc -ll' serves each connection in a process of its own.
+func makeTCPEchoDeploymentSpec(deployNamePrefix string, namespace string, labels map[string]string, replicas int32) *appsv1.Deployment {
+	script := fmt.Sprintf("printf '#!/bin/sh\\nhostname\\nexec cat\\n' > /tmp/echo.sh && chmod +x /tmp/echo.sh && "+
+		"nc -ll -p %d -e /tmp/echo.sh", TCPEchoPort)
+
+	deploy := makeEchoServerDeploymentSpec(deployNamePrefix, namespace, labels, replicas)
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{
+		{
+			Image:           "busybox",
+			Name:            "tcp-echo",
+			Command:         []string{"sh", "-c", script},
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "tcp-echo",
+					ContainerPort: TCPEchoPort,
+					Protocol:      corev1.ProtocolTCP,
+				},
+			},
+		},
+	}
+
+	return deploy
+}
+
+// longLivedConnection is a TCP connection held open through the service from a probe pod.
+type longLivedConnection struct {
+	Node        string
+	Backend     string
+	Sent        int
+	Echoed      int
+	Elapsed     time.Duration
+	Outcome     string
+	BrokenAfter time.Duration
+}
+
+// parseHeartbeatOutput parses the output of the heartbeat script: the hostname of the backend, the heartbeats
+// echoed back, and the seconds nc took in the last line.
+func parseHeartbeatOutput(output string, sent int, duration time.Duration, interval time.Duration) longLivedConnection {
+	connection := longLivedConnection{Sent: sent}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "hb-"):
+			connection.Echoed++
+		case strings.HasPrefix(line, "elapsed="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(line, "elapsed=")); err == nil {
+				connection.Elapsed = time.Duration(seconds) * time.Second
+			}
+		case connection.Backend == "" && line != "":
+			connection.Backend = line
+		}
+	}
+
+	switch {
+	case connection.Echoed >= sent:
+		connection.Outcome = ConnectionSurvived
+	case connection.Elapsed < duration:
+		// nc returned before the heartbeats ended, the connection was closed or reset
+		connection.Outcome = ConnectionReset
+		connection.BrokenAfter = time.Duration(connection.Echoed) * interval
+	default:
+		// heartbeats were written until the end, but not echoed any more
+		connection.Outcome = ConnectionStalled
+		connection.BrokenAfter = time.Duration(connection.Echoed) * interval
+	}
+
+	return connection
+}
+
+// holdConnection opens a connection to address from the pod and sends a heartbeat every interval for duration.
+func holdConnection(pod corev1.Pod, address string, duration time.Duration, interval time.Duration) longLivedConnection {
+	seconds := int(interval.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	sent := int(duration.Seconds()) / seconds
+	script := fmt.Sprintf("start=$(date +%%s); "+
+		"(i=0; while [ $i -lt %d ]; do echo hb-$i; i=$((i+1)); sleep %d; done; sleep 2) | nc -w 5 %s; "+
+		"echo; echo elapsed=$(( $(date +%%s) - start ))", sent, seconds, strings.Replace(address, ":", " ", 1))
+
+	glog.Infof("holding a connection from pod %s in node %s to %s for %s\n", pod.Name, pod.Spec.NodeName, address, duration)
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, []string{"sh", "-c", script}, clientset, config)
+	if err != nil {
+		glog.Infof("connection from pod %s ended with %v %s\n", pod.Name, err, stderr)
+	}
+	connection := parseHeartbeatOutput(stdout, sent, duration, time.Duration(seconds)*time.Second)
+	connection.Node = pod.Spec.NodeName
+
+	return connection
+}
+
+func skipUnlessLongLivedConnectionsAreEnabled() {
+	if *connectionDuration <= 0 {
+		Skip("long-lived connection test is run only with -connection-duration")
+	}
+}
+
+// updateServicePorts applies update to the ports of the current service.
+func updateServicePorts(svc *corev1.Service, update func(ports []corev1.ServicePort) []corev1.ServicePort) error {
+	current, err := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	current.Spec.Ports = update(current.Spec.Ports)
+	_, err = clientset.CoreV1().Services(svc.Namespace).Update(current)
+
+	return err
+}
+
+// changeService adds and removes endpoints and rewrites the ports of the service while connections are held,
+// so that kube-proxy rewrites its rules for the service several times, and returns the changes which failed.
+func changeService(svc *corev1.Service, deploy *appsv1.Deployment, replicas int32, duration time.Duration) []string {
+	step := duration / 5
+	changes := []struct {
+		description string
+		change      func() error
+	}{
+		{"add an endpoint", func() error {
+			return scaleDeployment(clientset, deploy.Name, deploy.Namespace, replicas+1)
+		}},
+		{"remove the added endpoint", func() error {
+			return scaleDeployment(clientset, deploy.Name, deploy.Namespace, replicas)
+		}},
+		{"add a service port", func() error {
+			return updateServicePorts(svc, func(ports []corev1.ServicePort) []corev1.ServicePort {
+				return append(ports, corev1.ServicePort{
+					Name:       "tcp-echo-extra",
+					Port:       TCPEchoPort + 1,
+					TargetPort: intstr.FromInt(TCPEchoPort),
+					Protocol:   corev1.ProtocolTCP,
+				})
+			})
+		}},
+		{"rename the service port and target the named container port", func() error {
+			return updateServicePorts(svc, func(ports []corev1.ServicePort) []corev1.ServicePort {
+				// the named container port is the same port number, so that new connections keep working
+				ports[0].Name = "tcp-echo-renamed"
+				ports[0].TargetPort = intstr.FromString("tcp-echo")
+				return ports
+			})
+		}},
+	}
+
+	var failed []string
+	for _, change := range changes {
+		time.Sleep(step)
+		glog.Infof("service %s : %s\n", svc.Name, change.description)
+		if err := change.change(); err != nil {
+			glog.Errorf("failed to %s of service %s: %v\n", change.description, svc.Name, err)
+			failed = append(failed, fmt.Sprintf("%s (%v)", change.description, err))
+		}
+	}
+
+	return failed
+}
+
+func formatConnections(connections []longLivedConnection) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "node\tbackend\theartbeats echoed\toutcome\tbroken after")
+	for _, connection := range connections {
+		brokenAfter := "-"
+		if connection.Outcome != ConnectionSurvived {
+			brokenAfter = connection.BrokenAfter.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", connection.Node, connection.Backend, connection.Echoed, connection.Sent,
+			connection.Outcome, brokenAfter)
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// checkLongLivedConnections holds a TCP connection through a ClusterIP service from the probe pod of every node
+// while the service is changed, and checks that no connection is reset or stalls.
+func checkLongLivedConnections() {
+	labels := map[string]string{"sntt": "tcp-echo"}
+	replicas := int32(*serviceReplicas)
+	deploy, err := createDeploymentObject(clientset, makeTCPEchoDeploymentSpec(PodName1Prefix, testingNamespace.Name, labels, replicas))
+	Expect(infrastructureError(err)).ToNot(HaveOccurred())
+	svc, err := createService(clientset, PodName1Prefix, testingNamespace.Name, labels, TCPEchoPort, corev1.ServiceTypeClusterIP)
+	Expect(err).ToNot(HaveOccurred())
+	dms, err := createDaemonset(clientset, PodName2Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = waitTimeoutForDeploymentReady(clientset, deploy.Name, deploy.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	address := fmt.Sprintf("%s:%d", svc.Spec.ClusterIP, TCPEchoPort)
+	duration := *connectionDuration
+	connections := make([]longLivedConnection, len(podList.Items))
+	var wg sync.WaitGroup
+	for i := range podList.Items {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			connections[i] = holdConnection(podList.Items[i], address, duration, *heartbeatInterval)
+		}(i)
+	}
+	failedChanges := changeService(svc, deploy, replicas, duration)
+	wg.Wait()
+	Expect(failedChanges).To(BeEmpty(), "service %s could not be changed while connections were held", svc.Name)
+
+	glog.Infof("========== long-lived connections to %s ==========\n%s", address, formatConnections(connections))
+
+	var broken []string
+	for i, connection := range connections {
+		result := makeNamedProbeResult(LongLivedCase, &podList.Items[i], address, EchoServiceName, ProbeKindService)
+		result.Attempts = connection.Sent
+		result.Successes = connection.Echoed
+		result.Classification = classify(connection.Sent, connection.Echoed)
+		result.Success = connection.Outcome == ConnectionSurvived
+		if !result.Success {
+			result.Message = fmt.Sprintf("%s after %s to backend %s", connection.Outcome, connection.BrokenAfter, connection.Backend)
+			broken = append(broken, fmt.Sprintf("%s (%s)", connection.Node, result.Message))
+		}
+		recordProbeResult(result)
+	}
+	Expect(broken).To(BeEmpty(), "connections were reset or stalled while the service changed")
+}
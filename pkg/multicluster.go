@@ -0,0 +1,263 @@
+package sntt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+)
+
+const (
+	MultiClusterCase            = "K"
+	MultiClusterServiceName     = "sntt-echo"
+	MultiClusterServiceAPIGroup = "multicluster.x-k8s.io"
+)
+
+// cluster is a cluster taking part in the multi-cluster test, reached through a kubeconfig context.
+type cluster struct {
+	name      string
+	clientset *kubernetes.Clientset
+	config    *restclient.Config
+
+	probePods  []corev1.Pod
+	echoPodIPs map[string]string // node name => echo server pod IP
+}
+
+// provisionCluster creates the namespace, a probe DaemonSet and an echo server DaemonSet behind a service in the
+// cluster, and exports the service when the multi-cluster services API is installed.
+func provisionCluster(c *cluster, namespace string) {
+	nsSpec := makeNamespaceSpec("")
+	nsSpec.Name = namespace
+	_, err := createNamespace(c.clientset, nsSpec)
+	Expect(err).ToNot(HaveOccurred())
+
+	dms, err := createDaemonset(c.clientset, PodName1Prefix, namespace)
+	Expect(err).ToNot(HaveOccurred())
+
+	echoLabels := map[string]string{"sntt": "echo"}
+	echoDms := makeDaemonsetSpec("echo-", namespace)
+	echoDms.Spec.Selector.MatchLabels = echoLabels
+	echoDms.Spec.Template.Labels = echoLabels
+	echoDms.Spec.Template.Spec.Containers = []corev1.Container{makeEchoServerContainer()}
+	echoDms, err = createDaemonsetObject(c.clientset, echoDms)
+	Expect(err).ToNot(HaveOccurred())
+
+	svcSpec := makeServiceSpec("", namespace, echoLabels, EchoServerPort, corev1.ServiceTypeClusterIP)
+	svcSpec.Name = MultiClusterServiceName
+	_, err = c.clientset.CoreV1().Services(namespace).Create(svcSpec)
+	Expect(err).ToNot(HaveOccurred())
+
+	if *exportedServiceDomain != "" {
+		exportService(c, namespace)
+	}
+
+	err = waitTimeoutForDaemonsetReady(c.clientset, dms.Name, namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(c.clientset, echoDms.Name, namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	podList, err := getPodsWithLabel(c.clientset, "sntt=daemonset", namespace)
+	Expect(err).ToNot(HaveOccurred())
+	c.probePods = podList.Items
+
+	echoPodList, err := getPodsWithLabel(c.clientset, "sntt=echo", namespace)
+	Expect(err).ToNot(HaveOccurred())
+	c.echoPodIPs = map[string]string{}
+	for _, echoPod := range echoPodList.Items {
+		c.echoPodIPs[echoPod.Spec.NodeName] = echoPod.Status.PodIP
+	}
+	glog.Infof("cluster %s is provisioned with %d probe pods\n", c.name, len(c.probePods))
+}
+
+// exportService creates a ServiceExport for the echo service so that it can be reached by its clusterset name.
+func exportService(c *cluster, namespace string) {
+	clusterDynamicClient, err := dynamic.NewForConfig(c.config)
+	Expect(err).ToNot(HaveOccurred())
+
+	gvr := getServedGroupVersionResourceFor(c.clientset, MultiClusterServiceAPIGroup, []string{"v1alpha1"}, "serviceexports")
+	if gvr == nil {
+		glog.Infof("ServiceExport is not served by cluster %s, the service is expected to be exported by other means\n", c.name)
+		return
+	}
+
+	serviceExport := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       "ServiceExport",
+			"metadata": map[string]interface{}{
+				"name":      MultiClusterServiceName,
+				"namespace": namespace,
+			},
+		},
+	}
+	_, err = clusterDynamicClient.Resource(*gvr).Namespace(namespace).Create(serviceExport, metav1.CreateOptions{})
+	Expect(err).ToNot(HaveOccurred())
+}
+
+// getServiceImportIP waits until the ServiceImport of the echo service in the cluster has an IP, and returns an
+// empty string when ServiceImport is not served or the import is headless.
+func getServiceImportIP(c *cluster, namespace string) string {
+	gvr := getServedGroupVersionResourceFor(c.clientset, MultiClusterServiceAPIGroup, []string{"v1alpha1"}, "serviceimports")
+	if gvr == nil {
+		glog.Infof("ServiceImport is not served by cluster %s, the service is requested by its clusterset name only\n", c.name)
+		return ""
+	}
+	clusterDynamicClient, err := dynamic.NewForConfig(c.config)
+	Expect(err).ToNot(HaveOccurred())
+
+	var ips []string
+	err = wait.PollImmediate(timeouts.PollingInterval, timeouts.Provisioning, func() (bool, error) {
+		serviceImport, err := clusterDynamicClient.Resource(*gvr).Namespace(namespace).Get(MultiClusterServiceName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		importType, _, _ := unstructured.NestedString(serviceImport.Object, "spec", "type")
+		ips, _, _ = unstructured.NestedStringSlice(serviceImport.Object, "spec", "ips")
+		return importType == "Headless" || len(ips) > 0, nil
+	})
+	Expect(infrastructureError(err)).ToNot(HaveOccurred(), "ServiceImport %s is not imported to cluster %s",
+		MultiClusterServiceName, c.name)
+	if len(ips) == 0 {
+		return ""
+	}
+
+	return ips[0]
+}
+
+// probeClusters pings the echo server pods on every node of the target cluster from every probe pod of the source
+// cluster, recording a ProbeResult for each pair. Services are not probed by the ClusterIP of another cluster, which
+// is not routable by design, but by their exported name in probeExportedService.
+func probeClusters(source *cluster, target *cluster, namespace string) {
+	var wg sync.WaitGroup
+	for _, pod := range source.probePods {
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			defer GinkgoRecover()
+
+			for nodeName, podIP := range target.echoPodIPs {
+				result := ProbeResult{
+					Case:          MultiClusterCase,
+					SourceCluster: source.name,
+					SourceNode:    pod.Spec.NodeName,
+					SourcePod:     pod.Name,
+					TargetCluster: target.name,
+					TargetNode:    nodeName,
+					Target:        podIP,
+					Kind:          ProbeKindPod,
+				}
+				evaluateProbe(result, getReachableExpectation(), func() bool {
+					return isPossibleToPingFromPodToIP(pod.Name, namespace, podIP, source.clientset, source.config)
+				})
+			}
+		}(pod)
+	}
+	wg.Wait()
+}
+
+// probeExportedService requests the echo service by its clusterset DNS name, and by the IP of its ServiceImport when
+// there is one, from every probe pod of the cluster.
+func probeExportedService(source *cluster, namespace string) {
+	targets := map[string]string{
+		ProbeKindDNS: fmt.Sprintf("http://%s.%s.svc.%s:%d/hostname", MultiClusterServiceName, namespace, *exportedServiceDomain,
+			EchoServerPort),
+	}
+	if importIP := getServiceImportIP(source, namespace); importIP != "" {
+		targets[ProbeKindService] = fmt.Sprintf("http://%s:%d/hostname", importIP, EchoServerPort)
+	}
+
+	var wg sync.WaitGroup
+	for _, pod := range source.probePods {
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			defer GinkgoRecover()
+
+			for kind, url := range targets {
+				result := ProbeResult{
+					Case:          MultiClusterCase,
+					SourceCluster: source.name,
+					SourceNode:    pod.Spec.NodeName,
+					SourcePod:     pod.Name,
+					TargetCluster: "clusterset",
+					TargetName:    MultiClusterServiceName,
+					Target:        url,
+					Kind:          kind,
+				}
+				evaluateProbe(result, getReachableExpectation(), func() bool {
+					return isPossibleToRequestFromPodToURL(pod.Name, namespace, url, source.clientset, source.config)
+				})
+			}
+		}(pod)
+	}
+	wg.Wait()
+}
+
+// checkMultiClusterConnectivity provisions probe pods in every cluster given by -cluster-contexts and checks
+// pod-to-pod and service reachability across them, printing a cross-cluster reachability matrix.
+func checkMultiClusterConnectivity() {
+	var clusters []*cluster
+	for _, context := range strings.Split(*clusterContexts, ",") {
+		context = strings.TrimSpace(context)
+		if context == "" {
+			continue
+		}
+		clusterClientset, clusterConfig, err := getClientSetForContext(context)
+		Expect(err).ToNot(HaveOccurred())
+		clusters = append(clusters, &cluster{name: context, clientset: clusterClientset, config: clusterConfig})
+	}
+	Expect(len(clusters)).To(BeNumerically(">=", 2), "-cluster-contexts needs at least two contexts")
+
+	// namespace sameness : 모든 cluster 에 같은 이름의 namespace 를 생성
+	namespace := NamespacePrefix + "multicluster-" + utilrand.String(5)
+	defer func() {
+		for _, c := range clusters {
+			err := c.clientset.CoreV1().Namespaces().Delete(namespace, &metav1.DeleteOptions{})
+			if err != nil {
+				glog.Infof("failed to delete namespace %s in cluster %s : %v\n", namespace, c.name, err)
+			}
+		}
+	}()
+	for _, c := range clusters {
+		provisionCluster(c, namespace)
+	}
+
+	for _, source := range clusters {
+		for _, target := range clusters {
+			if source == target {
+				continue
+			}
+			probeClusters(source, target, namespace)
+		}
+		if *exportedServiceDomain != "" {
+			probeExportedService(source, namespace)
+		}
+	}
+
+	results := getProbeResults(MultiClusterCase)
+	glog.Infof("========== cross-cluster reachability matrix ==========\n%s", formatReachabilityMatrix(results))
+
+	var unreachable []string
+	for _, result := range results {
+		if !result.Success {
+			unreachable = append(unreachable, fmt.Sprintf("%s => %s",
+				joinNonEmpty(result.SourceCluster, result.SourceNode), joinNonEmpty(result.TargetCluster, result.Target)))
+		}
+	}
+	Expect(unreachable).To(BeEmpty())
+}
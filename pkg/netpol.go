@@ -0,0 +1,552 @@
+package sntt
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	PolicyMatrixCase     = "S"
+	PolicyProbePodPrefix = "policy-probe-"
+
+	// PolicyWarmUp is how long probing waits after the probe pods are running, as a new pod may be probed before the
+	// policies are programmed for it. Allowed connections are also retried up to as long until their first success.
+	PolicyWarmUp = 10 * time.Second
+	// PolicyProbeAttempts is how many times every connection is probed. A single success of a connection expected to be
+	// denied is a leak, and a single failure of one expected to be allowed is a drop.
+	PolicyProbeAttempts = 3
+)
+
+// policyProbePod is a pod created by sntt in one of the namespaces whose NetworkPolicies are tested.
+type policyProbePod struct {
+	Namespace string
+	Name      string
+	Labels    map[string]string
+	IP        string
+	Node      string
+}
+
+func (p policyProbePod) key() string {
+	if p.Name == "" {
+		return p.Namespace + "/" + labels.Set(p.Labels).String()
+	}
+
+	return p.Namespace + "/" + p.Name
+}
+
+// policyProbePort is a TCP port every policy probe pod listens on, with the name of its container port.
+type policyProbePort struct {
+	Name string
+	Port int32
+}
+
+func (p policyProbePort) String() string {
+	if p.Name != "" {
+		return fmt.Sprintf("%d(%s)", p.Port, p.Name)
+	}
+
+	return strconv.Itoa(int(p.Port))
+}
+
+// policyVerdict tells whether a connection from a pod to a port of another pod is allowed by the policies.
+type policyVerdict struct {
+	From    policyProbePod
+	To      policyProbePod
+	Port    policyProbePort
+	Allowed bool
+}
+
+// parsePolicyPorts parses comma separated '[name=]port', e.g. 'http=8080,5432'.
+func parsePolicyPorts(value string) ([]policyProbePort, error) {
+	var ports []policyProbePort
+	for _, item := range splitFilter(value) {
+		port := policyProbePort{}
+		if i := strings.Index(item, "="); i >= 0 {
+			port.Name, item = item[:i], item[i+1:]
+		}
+		number, err := strconv.Atoi(item)
+		if err != nil || number < 1 || number > 65535 {
+			return nil, fmt.Errorf("invalid policy port '%s'", item)
+		}
+		port.Port = int32(number)
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no policy port is given")
+	}
+
+	return ports, nil
+}
+
+// parsePolicyProbePods parses semicolon separated '<namespace>/<labels>', e.g. 'prod/app=web,tier=front;prod/'.
+func parsePolicyProbePods(value string) ([]policyProbePod, error) {
+	var pods []policyProbePod
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "/", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("policy probe pod '%s' must be <namespace>/<labels>", item)
+		}
+		set, err := labels.ConvertSelectorToLabelsMap(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels of policy probe pod '%s': %v", item, err)
+		}
+		pods = append(pods, policyProbePod{Namespace: parts[0], Labels: set})
+	}
+
+	return pods, nil
+}
+
+// derivePolicyProbePods returns a pod without labels in every namespace, and a pod for the matchLabels of every pod
+// selector of the policies, in the namespace of the policy or in every namespace its namespace selector matches.
+// matchExpressions are not taken into account.
+func derivePolicyProbePods(policies []networkingv1.NetworkPolicy, namespaceLabels map[string]map[string]string) []policyProbePod {
+	var namespaces []string
+	for namespace := range namespaceLabels {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	var pods []policyProbePod
+	seen := map[string]bool{}
+	add := func(namespace string, set map[string]string) {
+		pod := policyProbePod{Namespace: namespace, Labels: map[string]string{}}
+		for key, value := range set {
+			pod.Labels[key] = value
+		}
+		if _, ok := namespaceLabels[namespace]; !ok || seen[pod.key()] {
+			return
+		}
+		seen[pod.key()] = true
+		pods = append(pods, pod)
+	}
+	addPeers := func(policy *networkingv1.NetworkPolicy, peers []networkingv1.NetworkPolicyPeer) {
+		for _, peer := range peers {
+			if peer.PodSelector == nil {
+				continue
+			}
+			if peer.NamespaceSelector == nil {
+				add(policy.Namespace, peer.PodSelector.MatchLabels)
+				continue
+			}
+			for _, namespace := range namespaces {
+				if selectorMatches(peer.NamespaceSelector, namespaceLabels[namespace]) {
+					add(namespace, peer.PodSelector.MatchLabels)
+				}
+			}
+		}
+	}
+
+	for _, namespace := range namespaces {
+		add(namespace, nil)
+	}
+	for i := range policies {
+		policy := &policies[i]
+		add(policy.Namespace, policy.Spec.PodSelector.MatchLabels)
+		for _, rule := range policy.Spec.Ingress {
+			addPeers(policy, rule.From)
+		}
+		for _, rule := range policy.Spec.Egress {
+			addPeers(policy, rule.To)
+		}
+	}
+
+	return pods
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+
+	return s.Matches(labels.Set(set))
+}
+
+// getPolicyTypes tells whether the policy isolates the pods it selects for ingress and for egress. Without
+// policyTypes a policy always isolates ingress, and egress only when it has egress rules.
+func getPolicyTypes(policy *networkingv1.NetworkPolicy) (bool, bool) {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return true, len(policy.Spec.Egress) > 0
+	}
+	ingress, egress := false, false
+	for _, policyType := range policy.Spec.PolicyTypes {
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			ingress = true
+		case networkingv1.PolicyTypeEgress:
+			egress = true
+		}
+	}
+
+	return ingress, egress
+}
+
+func ipBlockContains(block *networkingv1.IPBlock, ip string) bool {
+	address := net.ParseIP(ip)
+	_, cidr, err := net.ParseCIDR(block.CIDR)
+	if address == nil || err != nil || !cidr.Contains(address) {
+		return false
+	}
+	for _, except := range block.Except {
+		if _, exceptCIDR, err := net.ParseCIDR(except); err == nil && exceptCIDR.Contains(address) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// peersMatch tells whether the pod is one of the peers of a rule of a policy in policyNamespace. A rule without
+// peers matches every pod.
+func peersMatch(peers []networkingv1.NetworkPolicyPeer, policyNamespace string, pod policyProbePod,
+	namespaceLabels map[string]map[string]string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			if ipBlockContains(peer.IPBlock, pod.IP) {
+				return true
+			}
+			continue
+		}
+		if peer.NamespaceSelector == nil && pod.Namespace != policyNamespace {
+			continue
+		}
+		if peer.NamespaceSelector != nil && !selectorMatches(peer.NamespaceSelector, namespaceLabels[pod.Namespace]) {
+			continue
+		}
+		if peer.PodSelector == nil || selectorMatches(peer.PodSelector, pod.Labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// portsMatch tells whether a rule allows the TCP port of the destination pod. Named ports are resolved with the names
+// of the container ports of the destination pod. A rule without ports matches every port.
+func portsMatch(ports []networkingv1.NetworkPolicyPort, port policyProbePort) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, policyPort := range ports {
+		if policyPort.Protocol != nil && *policyPort.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		switch {
+		case policyPort.Port == nil:
+			return true
+		case policyPort.Port.Type == intstr.Int && policyPort.Port.IntVal == port.Port:
+			return true
+		case policyPort.Port.Type == intstr.String && port.Name != "" && policyPort.Port.StrVal == port.Name:
+			return true
+		}
+	}
+
+	return false
+}
+
+// isIngressAllowed tells whether the policies selecting the destination pod for ingress, if any, allow the connection.
+func isIngressAllowed(policies []networkingv1.NetworkPolicy, from policyProbePod, to policyProbePod, port policyProbePort,
+	namespaceLabels map[string]map[string]string) bool {
+	isolated := false
+	for i := range policies {
+		policy := &policies[i]
+		ingress, _ := getPolicyTypes(policy)
+		if !ingress || policy.Namespace != to.Namespace || !selectorMatches(&policy.Spec.PodSelector, to.Labels) {
+			continue
+		}
+		isolated = true
+		for _, rule := range policy.Spec.Ingress {
+			if peersMatch(rule.From, policy.Namespace, from, namespaceLabels) && portsMatch(rule.Ports, port) {
+				return true
+			}
+		}
+	}
+
+	return !isolated
+}
+
+// isEgressAllowed tells whether the policies selecting the source pod for egress, if any, allow the connection.
+func isEgressAllowed(policies []networkingv1.NetworkPolicy, from policyProbePod, to policyProbePod, port policyProbePort,
+	namespaceLabels map[string]map[string]string) bool {
+	isolated := false
+	for i := range policies {
+		policy := &policies[i]
+		_, egress := getPolicyTypes(policy)
+		if !egress || policy.Namespace != from.Namespace || !selectorMatches(&policy.Spec.PodSelector, from.Labels) {
+			continue
+		}
+		isolated = true
+		for _, rule := range policy.Spec.Egress {
+			if peersMatch(rule.To, policy.Namespace, to, namespaceLabels) && portsMatch(rule.Ports, port) {
+				return true
+			}
+		}
+	}
+
+	return !isolated
+}
+
+// computePolicyTruthTable returns whether every pod may connect to every port of every other pod, which needs both
+// the egress policies of the source and the ingress policies of the destination to allow it.
+func computePolicyTruthTable(policies []networkingv1.NetworkPolicy, namespaceLabels map[string]map[string]string,
+	pods []policyProbePod, ports []policyProbePort) []policyVerdict {
+	var verdicts []policyVerdict
+	for _, from := range pods {
+		for _, to := range pods {
+			if from.key() == to.key() {
+				continue
+			}
+			for _, port := range ports {
+				verdicts = append(verdicts, policyVerdict{
+					From:    from,
+					To:      to,
+					Port:    port,
+					Allowed: isEgressAllowed(policies, from, to, port, namespaceLabels) && isIngressAllowed(policies, from, to, port, namespaceLabels),
+				})
+			}
+		}
+	}
+
+	return verdicts
+}
+
+func formatVerdict(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+
+	return "deny"
+}
+
+// formatPolicyMatrix writes the expected verdict from every pod (rows) to every pod (columns) for every port, with
+// mismatching cells marked by '!' and the actual verdict, followed by a table of the mismatches.
+func formatPolicyMatrix(pods []policyProbePod, ports []policyProbePort, verdicts []policyVerdict, actual []bool) string {
+	cells := map[string]string{}
+	var mismatches []int
+	for i, verdict := range verdicts {
+		cell := formatVerdict(verdict.Allowed)
+		if actual[i] != verdict.Allowed {
+			cell = fmt.Sprintf("!%s(%s)", cell, formatVerdict(actual[i]))
+			mismatches = append(mismatches, i)
+		}
+		cells[verdict.From.key()+" "+verdict.To.key()+" "+verdict.Port.String()] = cell
+	}
+
+	var sb strings.Builder
+	for i, pod := range pods {
+		fmt.Fprintf(&sb, "[%d] %s %s %s\n", i, pod.key(), pod.IP, labels.Set(pod.Labels).String())
+	}
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	for _, port := range ports {
+		fmt.Fprintf(w, "port %s", port)
+		for i := range pods {
+			fmt.Fprintf(w, "\tto [%d]", i)
+		}
+		fmt.Fprintln(w)
+		for i, from := range pods {
+			fmt.Fprintf(w, "from [%d]", i)
+			for _, to := range pods {
+				cell, ok := cells[from.key()+" "+to.key()+" "+port.String()]
+				if !ok {
+					cell = "-"
+				}
+				fmt.Fprintf(w, "\t%s", cell)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	fmt.Fprintf(&sb, "mismatches : %d\n", len(mismatches))
+	if len(mismatches) > 0 {
+		w = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FROM\tTO\tPORT\tEXPECTED\tACTUAL")
+		for _, i := range mismatches {
+			verdict := verdicts[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", verdict.From.key(), verdict.To.key(), verdict.Port,
+				formatVerdict(verdict.Allowed), formatVerdict(actual[i]))
+		}
+		w.Flush()
+	}
+
+	return sb.String()
+}
+
+func makePolicyProbePodSpec(pod policyProbePod, ports []policyProbePort) *corev1.Pod {
+	script := "mkdir -p /tmp/www && hostname > /tmp/www/index.html"
+	var containerPorts []corev1.ContainerPort
+	for _, port := range ports {
+		script += fmt.Sprintf(" && httpd -p %d -h /tmp/www", port.Port)
+		containerPorts = append(containerPorts, corev1.ContainerPort{
+			Name:          port.Name,
+			ContainerPort: port.Port,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+
+	podSpec := makePodSpec(PolicyProbePodPrefix, pod.Namespace)
+	podSpec.Labels = pod.Labels
+	podSpec.Spec.Containers[0].Command = []string{"sh", "-c", script + "; sleep 3600"}
+	podSpec.Spec.Containers[0].Ports = containerPorts
+
+	return podSpec
+}
+
+// getPolicyExpectation returns the expectation of a connection the NetworkPolicies allow or deny.
+func getPolicyExpectation(allowed bool) Expectation {
+	duration := time.Duration(PolicyProbeAttempts-1) * timeouts.ProbeInterval
+	if !allowed {
+		return consistentlyUnreachable(duration, timeouts.ProbeInterval)
+	}
+	expectation := consistentlyReachable(duration, timeouts.ProbeInterval)
+	expectation.WarmUp = PolicyWarmUp
+
+	return expectation
+}
+
+// isPolicyConnectionAllowed requests the port of the target from the pod once.
+func isPolicyConnectionAllowed(from policyProbePod, to policyProbePod, port policyProbePort) bool {
+	url := fmt.Sprintf("http://%s:%d/", to.IP, port.Port)
+
+	return isPossibleToRequestFromPodToURL(from.Name, from.Namespace, url, clientset, config)
+}
+
+// getNetworkPolicies returns the NetworkPolicies and the labels of the namespaces of -policy-namespaces.
+func getNetworkPolicies() ([]networkingv1.NetworkPolicy, map[string]map[string]string, error) {
+	var policies []networkingv1.NetworkPolicy
+	namespaceLabels := map[string]map[string]string{}
+	for _, namespace := range splitFilter(*policyNamespaces) {
+		ns, err := clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		namespaceLabels[namespace] = ns.Labels
+		list, err := clientset.NetworkingV1().NetworkPolicies(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		policies = append(policies, list.Items...)
+	}
+
+	return policies, namespaceLabels, nil
+}
+
+func skipUnlessPolicyNamespacesAreGiven() {
+	if *policyNamespaces == "" {
+		Skip("NetworkPolicy conformance matrix is tested only with -policy-namespaces")
+	}
+}
+
+// checkPolicyMatrix creates probe pods in the namespaces of -policy-namespaces, computes whether every pod may
+// connect to every port of every other pod from the NetworkPolicies of the namespaces, and compares it with what is
+// actually allowed.
+func checkPolicyMatrix() {
+	ports, err := parsePolicyPorts(*policyPorts)
+	Expect(err).ToNot(HaveOccurred())
+	policies, namespaceLabels, err := getNetworkPolicies()
+	Expect(err).ToNot(HaveOccurred())
+	for _, policy := range policies {
+		glog.Infof("NetworkPolicy %s/%s\n", policy.Namespace, policy.Name)
+	}
+
+	pods := derivePolicyProbePods(policies, namespaceLabels)
+	if *policyProbePods != "" {
+		pods, err = parsePolicyProbePods(*policyProbePods)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	// probe pods are created in namespaces sntt does not own, so they are deleted here rather than with the namespace
+	defer func() {
+		for _, pod := range pods {
+			if pod.Name == "" {
+				continue
+			}
+			if err := clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil {
+				glog.Errorf("failed to delete policy probe pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+			}
+		}
+	}()
+	for i := range pods {
+		pods[i].Labels["sntt"] = "policy-probe"
+		pod, err := createPod(clientset, makePolicyProbePodSpec(pods[i], ports))
+		Expect(infrastructureError(err)).ToNot(HaveOccurred())
+		pods[i].Name = pod.Name
+	}
+	for i := range pods {
+		err = waitTimeoutForPodStatus(clientset, pods[i].Name, pods[i].Namespace, corev1.PodRunning, timeouts.Provisioning)
+		Expect(err).ToNot(HaveOccurred())
+		pod, err := clientset.CoreV1().Pods(pods[i].Namespace).Get(pods[i].Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		pods[i].IP = pod.Status.PodIP
+		pods[i].Node = pod.Spec.NodeName
+	}
+
+	glog.Infof("waiting %s for the NetworkPolicies to be programmed for the probe pods\n", PolicyWarmUp)
+	time.Sleep(PolicyWarmUp)
+
+	verdicts := computePolicyTruthTable(policies, namespaceLabels, pods, ports)
+	outcomes := make([]ProbeOutcome, len(verdicts))
+	actual := make([]bool, len(verdicts))
+	var wg sync.WaitGroup
+	for _, from := range pods {
+		wg.Add(1)
+		go func(from policyProbePod) {
+			defer wg.Done()
+			for i, verdict := range verdicts {
+				if verdict.From.key() == from.key() {
+					outcomes[i] = evaluateExpectation(getPolicyExpectation(verdict.Allowed), func() bool {
+						return isPolicyConnectionAllowed(verdict.From, verdict.To, verdict.Port)
+					})
+					// a connection whose expectation is not met is shown with the opposite verdict
+					actual[i] = outcomes[i].Met == verdict.Allowed
+				}
+			}
+		}(from)
+	}
+	wg.Wait()
+
+	glog.Infof("========== NetworkPolicy conformance matrix ==========\n%s", formatPolicyMatrix(pods, ports, verdicts, actual))
+
+	var mismatches []string
+	for i, verdict := range verdicts {
+		result := ProbeResult{
+			Case:       PolicyMatrixCase,
+			SourceNode: verdict.From.Node,
+			SourcePod:  verdict.From.key(),
+			TargetNode: verdict.To.Node,
+			TargetName: fmt.Sprintf("%s port %s from %s", verdict.To.key(), verdict.Port, verdict.From.key()),
+			Target:     fmt.Sprintf("%s:%d", verdict.To.IP, verdict.Port.Port),
+			Kind:       ProbeKindPod,
+		}
+		result = applyProbeOutcome(result, getPolicyExpectation(verdict.Allowed), outcomes[i])
+		if !result.Success {
+			mismatches = append(mismatches, fmt.Sprintf("%s => %s port %s (%s)", verdict.From.key(), verdict.To.key(),
+				verdict.Port, result.Message))
+		}
+		recordProbeResult(result)
+	}
+	Expect(mismatches).To(BeEmpty(), "connections are not allowed and denied as the NetworkPolicies say")
+}
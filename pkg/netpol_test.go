@@ -0,0 +1,265 @@
+package sntt
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var (
+	testNamespaceLabels = map[string]map[string]string{
+		"prod":    {"env": "prod"},
+		"staging": {"env": "staging"},
+	}
+	testWeb     = policyProbePod{Namespace: "prod", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.1.1"}
+	testDB      = policyProbePod{Namespace: "prod", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.1.2"}
+	testStaging = policyProbePod{Namespace: "staging", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.2.1"}
+	testHTTP    = policyProbePort{Name: "http", Port: 8080}
+	testPostgre = policyProbePort{Port: 5432}
+)
+
+func makeTestPolicy(namespace string, podSelector map[string]string, policyTypes []networkingv1.PolicyType,
+	ingress []networkingv1.NetworkPolicyIngressRule, egress []networkingv1.NetworkPolicyEgressRule) networkingv1.NetworkPolicy {
+	return networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: namespace},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: podSelector},
+			PolicyTypes: policyTypes,
+			Ingress:     ingress,
+			Egress:      egress,
+		},
+	}
+}
+
+func makeTestPorts(ports ...intstr.IntOrString) []networkingv1.NetworkPolicyPort {
+	var policyPorts []networkingv1.NetworkPolicyPort
+	for i := range ports {
+		policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{Port: &ports[i]})
+	}
+
+	return policyPorts
+}
+
+func TestPolicyVerdicts(t *testing.T) {
+	udp := corev1.ProtocolUDP
+	tests := []struct {
+		name     string
+		policies []networkingv1.NetworkPolicy
+		from     policyProbePod
+		to       policyProbePod
+		port     policyProbePort
+		allowed  bool
+	}{
+		{
+			name:    "no policies allow everything",
+			from:    testStaging,
+			to:      testDB,
+			port:    testPostgre,
+			allowed: true,
+		},
+		{
+			name:     "default deny ingress denies every source",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", nil, nil, nil, nil)},
+			from:     testWeb,
+			to:       testDB,
+			port:     testPostgre,
+			allowed:  false,
+		},
+		{
+			name:     "default deny ingress does not isolate egress",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", nil, nil, nil, nil)},
+			from:     testWeb,
+			to:       testStaging,
+			port:     testHTTP,
+			allowed:  true,
+		},
+		{
+			name: "ingress from pods of the same namespace on a port",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "db"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{
+					From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}},
+					Ports: makeTestPorts(intstr.FromInt(5432)),
+				}}, nil)},
+			from:    testWeb,
+			to:      testDB,
+			port:    testPostgre,
+			allowed: true,
+		},
+		{
+			name: "ingress from pods of the same namespace denies another port",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "db"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{
+					From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}},
+					Ports: makeTestPorts(intstr.FromInt(5432)),
+				}}, nil)},
+			from:    testWeb,
+			to:      testDB,
+			port:    testHTTP,
+			allowed: false,
+		},
+		{
+			name: "pod selector without namespace selector denies other namespaces",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "db"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}},
+				}}, nil)},
+			from:    testStaging,
+			to:      testDB,
+			port:    testPostgre,
+			allowed: false,
+		},
+		{
+			name: "named port is resolved with the container ports of the destination",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "web"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{Ports: makeTestPorts(intstr.FromString("http"))}}, nil)},
+			from:    testStaging,
+			to:      testWeb,
+			port:    testHTTP,
+			allowed: true,
+		},
+		{
+			name: "UDP port does not allow TCP",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "web"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp}}}}, nil)},
+			from:    testDB,
+			to:      testWeb,
+			port:    testHTTP,
+			allowed: false,
+		},
+		{
+			name: "namespace and pod selector in one peer need both to match",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "db"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "staging"}},
+						PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+					}},
+				}}, nil)},
+			from:    testStaging,
+			to:      testDB,
+			port:    testPostgre,
+			allowed: false,
+		},
+		{
+			name: "namespace and pod selector in separate peers match either",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "db"}, nil,
+				[]networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{
+						{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "staging"}}},
+						{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}},
+					},
+				}}, nil)},
+			from:    testStaging,
+			to:      testDB,
+			port:    testPostgre,
+			allowed: true,
+		},
+		{
+			name: "egress rules without policy types isolate egress",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "web"}, nil, nil,
+				[]networkingv1.NetworkPolicyEgressRule{{
+					To: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}}},
+				}})},
+			from:    testWeb,
+			to:      testStaging,
+			port:    testHTTP,
+			allowed: false,
+		},
+		{
+			name: "egress only policy does not isolate ingress",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "web"},
+				[]networkingv1.PolicyType{networkingv1.PolicyTypeEgress}, nil, nil)},
+			from:    testDB,
+			to:      testWeb,
+			port:    testHTTP,
+			allowed: true,
+		},
+		{
+			name: "ip block with an exception",
+			policies: []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "web"},
+				[]networkingv1.PolicyType{networkingv1.PolicyTypeEgress}, nil,
+				[]networkingv1.NetworkPolicyEgressRule{{
+					To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.2.0/24"}}}},
+				}})},
+			from:    testWeb,
+			to:      testStaging,
+			port:    testHTTP,
+			allowed: false,
+		},
+		{
+			name: "egress of the source and ingress of the destination must both allow",
+			policies: []networkingv1.NetworkPolicy{
+				makeTestPolicy("prod", map[string]string{"app": "web"}, []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}, nil,
+					[]networkingv1.NetworkPolicyEgressRule{{}}),
+				makeTestPolicy("staging", nil, nil, nil, nil),
+			},
+			from:    testWeb,
+			to:      testStaging,
+			port:    testHTTP,
+			allowed: false,
+		},
+	}
+
+	for _, test := range tests {
+		verdicts := computePolicyTruthTable(test.policies, testNamespaceLabels, []policyProbePod{test.from, test.to},
+			[]policyProbePort{test.port})
+		if len(verdicts) != 2 {
+			t.Fatalf("%s: %d verdicts, expected 2", test.name, len(verdicts))
+		}
+		if verdicts[0].Allowed != test.allowed {
+			t.Errorf("%s: %s => %s port %s allowed %v, expected %v", test.name, test.from.key(), test.to.key(), test.port,
+				verdicts[0].Allowed, test.allowed)
+		}
+	}
+}
+
+func TestDerivePolicyProbePods(t *testing.T) {
+	policies := []networkingv1.NetworkPolicy{makeTestPolicy("prod", map[string]string{"app": "db"}, nil,
+		[]networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{
+				{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+				{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "staging"}},
+					PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				},
+				{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+			},
+		}}, nil)}
+
+	var keys []string
+	for _, pod := range derivePolicyProbePods(policies, testNamespaceLabels) {
+		keys = append(keys, pod.key())
+	}
+	expected := []string{"prod/", "staging/", "prod/app=db", "prod/app=web", "staging/app=web"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("derived pods %v, expected %v", keys, expected)
+	}
+}
+
+func TestParsePolicyFlags(t *testing.T) {
+	ports, err := parsePolicyPorts("http=8080, 5432")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ports, []policyProbePort{testHTTP, testPostgre}) {
+		t.Errorf("parsed ports %v", ports)
+	}
+	if _, err := parsePolicyPorts("http=x"); err == nil {
+		t.Error("invalid port is parsed")
+	}
+
+	pods, err := parsePolicyProbePods("prod/app=web,tier=front; staging/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 2 || pods[0].key() != "prod/app=web,tier=front" || pods[1].key() != "staging/" {
+		t.Errorf("parsed pods %v", pods)
+	}
+	if _, err := parsePolicyProbePods("app=web"); err == nil {
+		t.Error("pod without namespace is parsed")
+	}
+}
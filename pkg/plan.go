@@ -0,0 +1,48 @@
+package sntt
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+)
+
+// TestPlan is the run configuration read from the YAML or JSON file given with -test-plan. Include and Exclude take
+// tags or case IDs like -include and -exclude.
+//
+//	include: [pod-to-pod, service]
+//	exclude: [slow, disruptive]
+//	failFast: true
+//	timeouts:
+//	  provisioning: 2m
+//	cases:
+//	  F-3:
+//	    timeouts:
+//	      provisioning: 10m
+type TestPlan struct {
+	Include  []string            `json:"include,omitempty"`
+	Exclude  []string            `json:"exclude,omitempty"`
+	FailFast bool                `json:"failFast,omitempty"`
+	Timeouts map[string]string   `json:"timeouts,omitempty"`
+	Cases    map[string]CasePlan `json:"cases,omitempty"`
+}
+
+// CasePlan is the configuration of a single case in the test plan, keyed by its case ID.
+type CasePlan struct {
+	Timeouts map[string]string `json:"timeouts,omitempty"`
+}
+
+var testPlan TestPlan
+
+func readTestPlan(path string) (TestPlan, error) {
+	plan := TestPlan{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+	if err := yaml.UnmarshalStrict(data, &plan); err != nil {
+		return plan, fmt.Errorf("failed to parse test plan %s: %v", path, err)
+	}
+
+	return plan, nil
+}
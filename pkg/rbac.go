@@ -0,0 +1,342 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// permission is a set of verbs sntt needs on a resource in any namespace.
+type permission struct {
+	group    string
+	resource string
+	verbs    []string
+}
+
+// namespacedPermission is a permission sntt needs only in a namespace it does not own, granted by a Role.
+type namespacedPermission struct {
+	namespace string
+	permission
+}
+
+var (
+	basePermissions = []permission{
+		{"", "namespaces", []string{"create", "delete", "get"}},
+		{"", "nodes", []string{"list"}},
+		{"", "pods", []string{"create", "delete", "get", "list"}},
+		{"", "pods/exec", []string{"create"}},
+		{"", "services", []string{"create", "get"}},
+		{"", "endpoints", []string{"get"}},
+		{"apps", "daemonsets", []string{"create", "delete", "get"}},
+		{"apps", "deployments", []string{"create", "get"}},
+	}
+	endpointPropagationPermissions = []permission{
+		{"apps", "deployments", []string{"update"}},
+		{"discovery.k8s.io", "endpointslices", []string{"list", "watch"}},
+	}
+	ingressPermissions = []permission{
+		{"", "services", []string{"list"}},
+		{"", "secrets", []string{"create"}},
+		{"networking.k8s.io", "ingresses", []string{"create"}},
+	}
+	gatewayPermissions = []permission{
+		{GatewayAPIGroup, "gatewayclasses", []string{"get", "list"}},
+		{GatewayAPIGroup, "gateways", []string{"create", "get"}},
+		{GatewayAPIGroup, "httproutes", []string{"create"}},
+	}
+	longLivedConnectionPermissions = []permission{
+		{"", "services", []string{"update"}},
+		{"apps", "deployments", []string{"update"}},
+	}
+	disruptivePermissions = []permission{
+		{"", "nodes", []string{"get", "update"}},
+		{"", "pods/eviction", []string{"create"}},
+		{"apps", "daemonsets", []string{"list"}},
+	}
+	resolverPathPermissions = []permission{
+		{"", "configmaps", []string{"get"}},
+	}
+	// policyMatrixPermissions are needed in every namespace of -policy-namespaces
+	policyMatrixPermissions = []permission{
+		{"", "pods", []string{"create", "delete", "get"}},
+		{"", "pods/exec", []string{"create"}},
+		{"networking.k8s.io", "networkpolicies", []string{"list"}},
+	}
+	multiClusterPermissions = []permission{
+		{MultiClusterServiceAPIGroup, "serviceexports", []string{"create"}},
+		{MultiClusterServiceAPIGroup, "serviceimports", []string{"get"}},
+	}
+)
+
+// getRequiredPermissions returns the permissions needed by the tests selected with the current flags.
+func getRequiredPermissions() []permission {
+	permissions := append([]permission{}, basePermissions...)
+	if isCaseSelected("I") {
+		permissions = append(permissions, ingressPermissions...)
+	}
+	if isCaseSelected("J") {
+		permissions = append(permissions, gatewayPermissions...)
+	}
+	if *measureEndpointPropagation && isCaseSelected("H") {
+		permissions = append(permissions, endpointPropagationPermissions...)
+	}
+	if *connectionDuration > 0 && isCaseSelected(LongLivedCase) {
+		permissions = append(permissions, longLivedConnectionPermissions...)
+	}
+	if isCaseSelected(ResolverPathCase) {
+		permissions = append(permissions, resolverPathPermissions...)
+	}
+	if isDisruptionConfirmed() && isCaseSelected(DisruptionCase) {
+		permissions = append(permissions, disruptivePermissions...)
+	}
+	if *clusterContexts != "" && *exportedServiceDomain != "" && isCaseSelected(MultiClusterCase) {
+		permissions = append(permissions, multiClusterPermissions...)
+	}
+
+	return permissions
+}
+
+// getRequiredNamespacedPermissions returns the permissions needed by the tests selected with the current flags in
+// namespaces sntt does not own.
+func getRequiredNamespacedPermissions() []namespacedPermission {
+	var permissions []namespacedPermission
+	if isCaseSelected(PolicyMatrixCase) {
+		for _, namespace := range splitFilter(*policyNamespaces) {
+			for _, p := range policyMatrixPermissions {
+				permissions = append(permissions, namespacedPermission{namespace, p})
+			}
+		}
+	}
+
+	return permissions
+}
+
+// getMultiClusterPermissions returns the permissions needed in every cluster of -cluster-contexts.
+func getMultiClusterPermissions() []permission {
+	permissions := append([]permission{}, basePermissions...)
+	if *exportedServiceDomain != "" {
+		permissions = append(permissions, multiClusterPermissions...)
+	}
+
+	return permissions
+}
+
+// checkRequiredPermissions checks the permissions of the selected tests in the cluster of the current context and,
+// for the multi-cluster test, in every cluster of -cluster-contexts, and returns all missing permissions together.
+func checkRequiredPermissions() ([]string, error) {
+	missing, err := checkPermissions(clientset, getRequiredPermissions(), getRequiredNamespacedPermissions())
+	if err != nil {
+		return nil, err
+	}
+	if *clusterContexts == "" || !isCaseSelected(MultiClusterCase) {
+		return missing, nil
+	}
+
+	for _, context := range splitFilter(*clusterContexts) {
+		contextClientset, _, err := getClientSetForContext(context)
+		if err != nil {
+			return nil, fmt.Errorf("context %s : %v", context, err)
+		}
+		contextMissing, err := checkPermissions(contextClientset, getMultiClusterPermissions(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("context %s : %v", context, err)
+		}
+		for _, m := range contextMissing {
+			missing = append(missing, fmt.Sprintf("%s in context %s", m, context))
+		}
+	}
+
+	return missing, nil
+}
+
+// checkPermissions asks the API server with SelfSubjectAccessReviews whether the current identity is allowed every
+// verb of permissions in all namespaces and of namespacedPermissions in their namespace, and returns all missing
+// permissions together.
+func checkPermissions(clientset *kubernetes.Clientset, permissions []permission,
+	namespacedPermissions []namespacedPermission) ([]string, error) {
+	var missing []string
+	for _, p := range permissions {
+		denied, err := reviewPermission(clientset, p, "")
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, denied...)
+	}
+	for _, p := range namespacedPermissions {
+		denied, err := reviewPermission(clientset, p.permission, p.namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range denied {
+			missing = append(missing, fmt.Sprintf("%s in namespace %s", d, p.namespace))
+		}
+	}
+
+	return missing, nil
+}
+
+// reviewPermission returns the verbs of p which are not allowed in namespace, or in all namespaces when it is empty.
+func reviewPermission(clientset *kubernetes.Clientset, p permission, namespace string) ([]string, error) {
+	var denied []string
+	resource, subresource := p.resource, ""
+	if parts := strings.SplitN(p.resource, "/", 2); len(parts) == 2 {
+		resource, subresource = parts[0], parts[1]
+	}
+	for _, verb := range p.verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Group:       p.group,
+					Resource:    resource,
+					Subresource: subresource,
+					Verb:        verb,
+				},
+			},
+		}
+		reviewOut, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if err != nil {
+			return nil, err
+		}
+		if !reviewOut.Status.Allowed {
+			denied = append(denied, fmt.Sprintf("%s %s", verb, joinNonEmpty(p.group, p.resource)))
+		}
+	}
+
+	return denied, nil
+}
+
+// makePolicyRules returns the rules granting exactly permissions, a rule per resource.
+func makePolicyRules(permissions []permission) []rbacv1.PolicyRule {
+	verbsByResource := map[string]map[string]bool{}
+	var keys []string
+	for _, p := range permissions {
+		key := p.group + "|" + p.resource
+		if _, ok := verbsByResource[key]; !ok {
+			verbsByResource[key] = map[string]bool{}
+			keys = append(keys, key)
+		}
+		for _, verb := range p.verbs {
+			verbsByResource[key][verb] = true
+		}
+	}
+	sort.Strings(keys)
+
+	var rules []rbacv1.PolicyRule
+	for _, key := range keys {
+		parts := strings.SplitN(key, "|", 2)
+		var verbs []string
+		for verb := range verbsByResource[key] {
+			verbs = append(verbs, verb)
+		}
+		sort.Strings(verbs)
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{parts[0]},
+			Resources: []string{parts[1]},
+			Verbs:     verbs,
+		})
+	}
+
+	return rules
+}
+
+// makeClusterRole returns a ClusterRole granting exactly permissions.
+func makeClusterRole(name string, permissions []permission) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRole",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Rules: makePolicyRules(permissions),
+	}
+}
+
+// makeRoles returns a Role per namespace granting exactly the namespaced permissions of the namespace, in the order
+// the namespaces first appear.
+func makeRoles(name string, permissions []namespacedPermission) []*rbacv1.Role {
+	permissionsByNamespace := map[string][]permission{}
+	var namespaces []string
+	for _, p := range permissions {
+		if _, ok := permissionsByNamespace[p.namespace]; !ok {
+			namespaces = append(namespaces, p.namespace)
+		}
+		permissionsByNamespace[p.namespace] = append(permissionsByNamespace[p.namespace], p.permission)
+	}
+
+	var roles []*rbacv1.Role
+	for _, namespace := range namespaces {
+		roles = append(roles, &rbacv1.Role{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Role",
+				APIVersion: "rbac.authorization.k8s.io/v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Rules: makePolicyRules(permissionsByNamespace[namespace]),
+		})
+	}
+
+	return roles
+}
+
+// makeRoleBinding returns a RoleBinding of the role to a placeholder user, to be replaced before it is applied.
+func makeRoleBinding(role *rbacv1.Role) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      role.Name,
+			Namespace: role.Namespace,
+		},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "<user>"},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+	}
+}
+
+// makeRBACManifest returns the YAML of the ClusterRole, and of the Roles and RoleBindings in namespaces sntt does not
+// own, needed by the tests selected with the current flags.
+func makeRBACManifest() (string, error) {
+	objects := []interface{}{makeClusterRole("sntt", getRequiredPermissions())}
+	roles := makeRoles("sntt", getRequiredNamespacedPermissions())
+	for _, role := range roles {
+		objects = append(objects, role, makeRoleBinding(role))
+	}
+
+	var documents []string
+	for _, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, string(document))
+	}
+
+	header := "# bind it with: kubectl create clusterrolebinding sntt --clusterrole=sntt --user=<user>\n"
+	if len(roles) > 0 {
+		header += "# and replace <user> in the RoleBindings\n"
+	}
+	if *clusterContexts != "" && isCaseSelected(MultiClusterCase) {
+		header += "# apply it in every cluster of -cluster-contexts as well\n"
+	}
+
+	return header + strings.Join(documents, "---\n"), nil
+}
@@ -0,0 +1,35 @@
+package sntt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMakeRoles(t *testing.T) {
+	pods := permission{"", "pods", []string{"get", "create"}}
+	policies := permission{"networking.k8s.io", "networkpolicies", []string{"list"}}
+	roles := makeRoles("sntt", []namespacedPermission{
+		{"staging", pods},
+		{"prod", pods},
+		{"staging", policies},
+		{"staging", permission{"", "pods", []string{"delete"}}},
+	})
+
+	if len(roles) != 2 || roles[0].Namespace != "staging" || roles[1].Namespace != "prod" {
+		t.Fatalf("roles %v, expected one in staging and one in prod", roles)
+	}
+	var rules []string
+	for _, rule := range roles[0].Rules {
+		rules = append(rules, joinNonEmpty(rule.APIGroups[0], rule.Resources[0])+":"+strings.Join(rule.Verbs, ","))
+	}
+	expected := []string{"networking.k8s.io/networkpolicies:list", "pods:create,delete,get"}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("rules of staging %v, expected %v", rules, expected)
+	}
+
+	binding := makeRoleBinding(roles[1])
+	if binding.Namespace != "prod" || binding.RoleRef.Kind != "Role" || binding.RoleRef.Name != "sntt" {
+		t.Errorf("role binding %+v does not bind the role of prod", binding)
+	}
+}
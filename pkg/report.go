@@ -0,0 +1,164 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/golang/glog"
+)
+
+// ProbeResult is the outcome of a probe from a source pod to a target. Success tells whether the expectation of the
+// pair was met, Classification what was actually observed over the attempts.
+type ProbeResult struct {
+	Case           string  `json:"case"`
+	SourceCluster  string  `json:"sourceCluster,omitempty"`
+	SourceNode     string  `json:"sourceNode"`
+	SourcePod      string  `json:"sourcePod"`
+	SourceZone     string  `json:"sourceZone,omitempty"`
+	TargetCluster  string  `json:"targetCluster,omitempty"`
+	TargetNode     string  `json:"targetNode,omitempty"`
+	TargetZone     string  `json:"targetZone,omitempty"`
+	TargetName     string  `json:"targetName,omitempty"`
+	Target         string  `json:"target"`
+	Kind           string  `json:"kind"`
+	Success        bool    `json:"success"`
+	Expectation    string  `json:"expectation,omitempty"`
+	Classification string  `json:"classification,omitempty"`
+	Attempts       int     `json:"attempts,omitempty"`
+	Successes      int     `json:"successes,omitempty"`
+	LatencyMillis  float64 `json:"latencyMillis,omitempty"`
+	Message        string  `json:"message,omitempty"`
+}
+
+const (
+	ProbeKindPod      = "pod"
+	ProbeKindNode     = "node"
+	ProbeKindService  = "service"
+	ProbeKindDNS      = "dns"
+	ProbeKindExternal = "external"
+)
+
+// UncoveredNode is a node on which a probe DaemonSet had no running pod.
+type UncoveredNode struct {
+	Node      string `json:"node"`
+	DaemonSet string `json:"daemonSet"`
+	Reason    string `json:"reason"`
+}
+
+var (
+	resultsMutex   sync.Mutex
+	probeResults   []ProbeResult
+	uncoveredNodes []UncoveredNode
+)
+
+func recordProbeResult(result ProbeResult) {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	probeResults = append(probeResults, result)
+}
+
+func recordUncoveredNode(uncoveredNode UncoveredNode) {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	uncoveredNodes = append(uncoveredNodes, uncoveredNode)
+}
+
+// reportUncoveredNodes logs every node which was not covered by probe DaemonSets and why.
+func reportUncoveredNodes() {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	if len(uncoveredNodes) == 0 {
+		return
+	}
+	reasons := map[string][]string{}
+	var nodeNames []string
+	for _, uncoveredNode := range uncoveredNodes {
+		if _, ok := reasons[uncoveredNode.Node]; !ok {
+			nodeNames = append(nodeNames, uncoveredNode.Node)
+		}
+		if !containsString(reasons[uncoveredNode.Node], uncoveredNode.Reason) {
+			reasons[uncoveredNode.Node] = append(reasons[uncoveredNode.Node], uncoveredNode.Reason)
+		}
+	}
+	sort.Strings(nodeNames)
+
+	glog.Info("========== nodes not covered by probe pods ==========\n")
+	for _, nodeName := range nodeNames {
+		glog.Infof("%s : %s\n", nodeName, strings.Join(reasons[nodeName], ", "))
+	}
+}
+
+func getProbeResults(caseName string) []ProbeResult {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	var caseResults []ProbeResult
+	for _, result := range probeResults {
+		if result.Case == caseName {
+			caseResults = append(caseResults, result)
+		}
+	}
+
+	return caseResults
+}
+
+func joinNonEmpty(values ...string) string {
+	var nonEmpty []string
+	for _, value := range values {
+		if value != "" {
+			nonEmpty = append(nonEmpty, value)
+		}
+	}
+
+	return strings.Join(nonEmpty, "/")
+}
+
+// formatReachabilityMatrix renders results as a table with a row per source node and a column per target.
+func formatReachabilityMatrix(results []ProbeResult) string {
+	var rows, columns []string
+	cells := map[string]map[string]string{}
+	for _, result := range results {
+		row := joinNonEmpty(result.SourceCluster, result.SourceNode)
+		column := joinNonEmpty(result.TargetCluster, result.Kind, result.TargetNode)
+		if result.TargetNode == "" {
+			column = joinNonEmpty(result.TargetCluster, result.Kind, result.Target)
+		}
+		if _, ok := cells[row]; !ok {
+			cells[row] = map[string]string{}
+			rows = append(rows, row)
+		}
+		if !containsString(columns, column) {
+			columns = append(columns, column)
+		}
+		cells[row][column] = "O"
+		if !result.Success {
+			cells[row][column] = "X"
+		}
+	}
+	sort.Strings(rows)
+	sort.Strings(columns)
+
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "SOURCE \\ TARGET\t%s\n", strings.Join(columns, "\t"))
+	for _, row := range rows {
+		line := []string{row}
+		for _, column := range columns {
+			cell, ok := cells[row][column]
+			if !ok {
+				cell = "-"
+			}
+			line = append(line, cell)
+		}
+		fmt.Fprintln(writer, strings.Join(line, "\t"))
+	}
+	writer.Flush()
+
+	return builder.String()
+}
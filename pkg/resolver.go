@@ -0,0 +1,378 @@
+package sntt
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ResolverPathCase = "R"
+
+	ResolverKubeDNS   = "kube-dns"
+	ResolverNodeLocal = "nodelocal"
+	ResolverCustom    = "custom"
+)
+
+// classifyResolver tells whether nameserver is the cluster DNS service, the NodeLocal DNSCache address or something
+// else.
+func classifyResolver(nameserver string, dnsServiceIP string, nodeLocalIP string) string {
+	switch nameserver {
+	case dnsServiceIP:
+		return ResolverKubeDNS
+	case nodeLocalIP:
+		return ResolverNodeLocal
+	}
+
+	return ResolverCustom
+}
+
+// getClusterDomain returns the cluster domain from the "svc.<cluster domain>" search domain of the pod.
+func getClusterDomain(conf resolvConf) string {
+	for _, domain := range conf.Search {
+		if strings.HasPrefix(domain, "svc.") {
+			return strings.TrimSuffix(strings.TrimPrefix(domain, "svc."), ".")
+		}
+	}
+
+	return "cluster.local"
+}
+
+// getExpectedResolver returns the resolver pods are expected to use, from -expected-resolver or, when empty,
+// NodeLocal DNSCache if its DaemonSet exists and kube-dns otherwise.
+func getExpectedResolver() (string, error) {
+	switch *expectedResolver {
+	case ResolverKubeDNS, ResolverNodeLocal:
+		return *expectedResolver, nil
+	case "":
+	default:
+		return "", fmt.Errorf("-expected-resolver must be %s or %s", ResolverKubeDNS, ResolverNodeLocal)
+	}
+
+	parts := strings.SplitN(*nodeLocalDNSDaemonset, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("-nodelocal-dns-daemonset must be <namespace>/<name>")
+	}
+	_, err := clientset.AppsV1().DaemonSets(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return ResolverKubeDNS, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return ResolverNodeLocal, nil
+}
+
+// lookupFromPod looks up name with the query type from server in the pod and returns the response code.
+func lookupFromPod(pod *corev1.Pod, name string, queryType string, server string) string {
+	command := []string{"nslookup", "-type=" + queryType, name, server}
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, command, clientset, config)
+	rcode := categorizeDNSResponse(stdout + stderr)
+	if err != nil && rcode == RcodeNoError {
+		rcode = RcodeOther
+	}
+
+	return rcode
+}
+
+func isAnswered(rcode string) bool {
+	return rcode == RcodeNoError || rcode == RcodeNoData || rcode == RcodeNXDomain
+}
+
+// resolverPath is the resolver the pod of a node uses and whether it answers.
+type resolverPath struct {
+	Node       string
+	Nameserver string
+	Resolver   string
+	Expected   string
+	NodeLocal  string
+	Response   string
+	Mismatch   string
+}
+
+// getResolverPath looks up the kubernetes service from the nameserver of the pod, and from NodeLocal DNSCache on the
+// node of the pod, and tells how the path differs from the expected resolver.
+func getResolverPath(pod *corev1.Pod, dnsServiceIP string, expected string) resolverPath {
+	path := resolverPath{Node: pod.Spec.NodeName, Expected: expected, NodeLocal: "-"}
+	conf, err := getResolvConfOfPod(pod)
+	if err != nil || len(conf.Nameservers) == 0 {
+		path.Mismatch = "no nameserver in /etc/resolv.conf"
+		return path
+	}
+	path.Nameserver = conf.Nameservers[0]
+	path.Resolver = classifyResolver(path.Nameserver, dnsServiceIP, *nodeLocalDNSIP)
+
+	name := fmt.Sprintf("kubernetes.default.svc.%s.", getClusterDomain(conf))
+	path.Response = lookupFromPod(pod, name, "A", path.Nameserver)
+	if expected == ResolverNodeLocal {
+		path.NodeLocal = lookupFromPod(pod, name, "A", *nodeLocalDNSIP)
+	}
+
+	var mismatches []string
+	switch {
+	case path.Resolver == ResolverCustom:
+		mismatches = append(mismatches, fmt.Sprintf("nameserver is neither %s nor %s", dnsServiceIP, *nodeLocalDNSIP))
+	case expected == ResolverKubeDNS && path.Resolver == ResolverNodeLocal:
+		mismatches = append(mismatches, "pod uses NodeLocal DNSCache, but kube-dns is expected")
+	}
+	// with NodeLocal DNSCache in iptables mode pods keep the kube-dns nameserver, and the cache answers for it
+	if expected == ResolverNodeLocal && path.NodeLocal != RcodeNoError {
+		mismatches = append(mismatches, fmt.Sprintf("NodeLocal DNSCache does not answer at %s", *nodeLocalDNSIP))
+	}
+	if path.Response != RcodeNoError {
+		mismatches = append(mismatches, fmt.Sprintf("%s is not resolved by %s", name, path.Nameserver))
+	}
+	path.Mismatch = strings.Join(mismatches, ", ")
+
+	return path
+}
+
+func formatResolverPaths(paths []resolverPath) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tNAMESERVER\tRESOLVER\tEXPECTED\tNODELOCAL\tRESPONSE\tMISMATCH")
+	for _, path := range paths {
+		mismatch := path.Mismatch
+		if mismatch == "" {
+			mismatch = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", path.Node, path.Nameserver, path.Resolver, path.Expected,
+			path.NodeLocal, path.Response, mismatch)
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// corefileServerBlock is a server block of a Corefile with the upstreams of its forward plugin.
+type corefileServerBlock struct {
+	Zones      []string
+	Forwarders []string
+}
+
+// parseCorefile returns the server blocks of a Corefile, e.g. '.:53 { forward . /etc/resolv.conf }' and
+// 'consul.local:53 { forward . 10.150.0.1 }'. Blocks of plugins inside a server block are skipped.
+func parseCorefile(content string) []corefileServerBlock {
+	var blocks []corefileServerBlock
+	depth := 0
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(strings.NewReplacer("{", " { ", "}", " } ").Replace(line))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case depth == 0 && fields[0] != "{" && fields[0] != "}":
+			block := corefileServerBlock{}
+			for _, field := range fields {
+				if field == "{" {
+					break
+				}
+				zone := strings.TrimPrefix(field, "dns://")
+				if host, _, err := net.SplitHostPort(zone); err == nil {
+					zone = host
+				}
+				block.Zones = append(block.Zones, strings.TrimSuffix(zone, ","))
+			}
+			blocks = append(blocks, block)
+		case depth == 1 && (fields[0] == "forward" || fields[0] == "proxy") && len(blocks) > 0 && len(fields) > 2:
+			for _, field := range fields[2:] {
+				if field == "{" {
+					break
+				}
+				blocks[len(blocks)-1].Forwarders = append(blocks[len(blocks)-1].Forwarders, field)
+			}
+		}
+
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+	}
+
+	return blocks
+}
+
+// getForwarderIP returns the IP of an upstream of the forward plugin, or "" when it is not a plain DNS server, e.g.
+// /etc/resolv.conf of the node or a tls:// upstream.
+func getForwarderIP(forwarder string) string {
+	if strings.Contains(forwarder, "://") && !strings.HasPrefix(forwarder, "dns://") {
+		return ""
+	}
+	forwarder = strings.TrimPrefix(forwarder, "dns://")
+	if host, _, err := net.SplitHostPort(forwarder); err == nil {
+		forwarder = host
+	}
+	if net.ParseIP(forwarder) == nil {
+		return ""
+	}
+
+	return forwarder
+}
+
+// forwarderCheck is a name of a zone with forwarders looked up through the cluster DNS and from a forwarder directly.
+type forwarderCheck struct {
+	Node         string
+	Zone         string
+	Name         string
+	Forwarder    string
+	ViaCluster   string
+	ViaForwarder string
+	Mismatch     string
+}
+
+// checkForwarders looks up a name of every zone with forwarders from the nameserver of the pod and from every
+// forwarder of the zone. The root zone is checked with -dns-upstream-name and stub domains with their SOA record.
+func checkForwarders(pod *corev1.Pod, blocks []corefileServerBlock) []forwarderCheck {
+	var checks []forwarderCheck
+	conf, err := getResolvConfOfPod(pod)
+	if err != nil || len(conf.Nameservers) == 0 {
+		return []forwarderCheck{{Node: pod.Spec.NodeName, Mismatch: "no nameserver in /etc/resolv.conf"}}
+	}
+
+	for _, block := range blocks {
+		for _, zone := range block.Zones {
+			if len(block.Forwarders) == 0 {
+				continue
+			}
+			name, queryType := strings.TrimSuffix(zone, ".")+".", "SOA"
+			if zone == "." {
+				name, queryType = *dnsUpstreamName, "A"
+			}
+			viaCluster := lookupFromPod(pod, name, queryType, conf.Nameservers[0])
+			for _, forwarder := range block.Forwarders {
+				check := forwarderCheck{Node: pod.Spec.NodeName, Zone: zone, Name: name, Forwarder: forwarder,
+					ViaCluster: viaCluster, ViaForwarder: "-"}
+				if ip := getForwarderIP(forwarder); ip != "" {
+					check.ViaForwarder = lookupFromPod(pod, name, queryType, ip)
+				}
+				switch {
+				case !isAnswered(check.ViaCluster):
+					check.Mismatch = fmt.Sprintf("not resolved through %s", conf.Nameservers[0])
+				case check.ViaForwarder != "-" && check.ViaForwarder != check.ViaCluster:
+					check.Mismatch = "answered differently than by the forwarder"
+				}
+				checks = append(checks, check)
+			}
+		}
+	}
+
+	return checks
+}
+
+func formatForwarderChecks(checks []forwarderCheck) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tZONE\tNAME\tFORWARDER\tVIA CLUSTER DNS\tVIA FORWARDER\tMISMATCH")
+	for _, check := range checks {
+		mismatch := check.Mismatch
+		if mismatch == "" {
+			mismatch = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", check.Node, check.Zone, check.Name, check.Forwarder,
+			check.ViaCluster, check.ViaForwarder, mismatch)
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// checkResolverPath checks that the pod of every node uses the expected resolver, kube-dns or NodeLocal DNSCache,
+// and that it answers, and reports mismatches per node.
+func checkResolverPath() {
+	dnsService, _, err := getDNSService()
+	Expect(err).ToNot(HaveOccurred())
+	expected, err := getExpectedResolver()
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("pods are expected to use %s, the cluster DNS service is %s\n", expected, dnsService.Spec.ClusterIP)
+
+	pods := getProbePodsByNode()
+	paths := make([]resolverPath, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i] = getResolverPath(&pods[i], dnsService.Spec.ClusterIP, expected)
+		}(i)
+	}
+	wg.Wait()
+
+	glog.Infof("========== DNS resolver per node ==========\n%s", formatResolverPaths(paths))
+
+	var mismatches []string
+	for i, path := range paths {
+		result := makeNamedProbeResult(ResolverPathCase, &pods[i], path.Nameserver, "resolv.conf", ProbeKindDNS)
+		result.Expectation = "resolver " + expected
+		result.Success = path.Mismatch == ""
+		result.Message = path.Mismatch
+		recordProbeResult(result)
+		if path.Mismatch != "" {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%s)", path.Node, path.Mismatch))
+		}
+	}
+	Expect(mismatches).To(BeEmpty(), "pods do not use the expected resolver")
+}
+
+// checkCorefileForwarders checks that the stub domains and upstream forwarders of the CoreDNS ConfigMap resolve
+// through the cluster DNS as they do from the forwarders, from every node.
+func checkCorefileForwarders() {
+	parts := strings.SplitN(*corednsConfigMap, "/", 2)
+	Expect(parts).To(HaveLen(2), "-coredns-configmap must be <namespace>/<name>")
+	cm, err := clientset.CoreV1().ConfigMaps(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		Skip(fmt.Sprintf("ConfigMap %s is not found, the cluster DNS may not be CoreDNS", *corednsConfigMap))
+	}
+	Expect(err).ToNot(HaveOccurred())
+	corefile, ok := cm.Data["Corefile"]
+	if !ok {
+		Skip(fmt.Sprintf("ConfigMap %s has no Corefile", *corednsConfigMap))
+	}
+	blocks := parseCorefile(corefile)
+	for _, block := range blocks {
+		glog.Infof("Corefile zones %v forward to %v\n", block.Zones, block.Forwarders)
+	}
+
+	pods := getProbePodsByNode()
+	checksPerPod := make([][]forwarderCheck, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			checksPerPod[i] = checkForwarders(&pods[i], blocks)
+		}(i)
+	}
+	wg.Wait()
+
+	var checks []forwarderCheck
+	for _, podChecks := range checksPerPod {
+		checks = append(checks, podChecks...)
+	}
+	glog.Infof("========== CoreDNS stub domains and forwarders ==========\n%s", formatForwarderChecks(checks))
+
+	var mismatches []string
+	for i, podChecks := range checksPerPod {
+		for _, check := range podChecks {
+			result := makeNamedProbeResult(ResolverPathCase, &pods[i], fmt.Sprintf("%s @%s", check.Name, check.Forwarder),
+				check.Name+" of zone "+check.Zone, ProbeKindDNS)
+			result.Success = check.Mismatch == ""
+			result.Message = check.Mismatch
+			recordProbeResult(result)
+			if check.Mismatch != "" {
+				mismatches = append(mismatches, fmt.Sprintf("%s => %s of zone %s (%s)", check.Node, check.Name, check.Zone, check.Mismatch))
+			}
+		}
+	}
+	Expect(mismatches).To(BeEmpty(), "stub domains or forwarders do not resolve as configured")
+}
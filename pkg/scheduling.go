@@ -0,0 +1,250 @@
+package sntt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// daemonsetDefaultTolerationKeys are the taints the DaemonSet controller tolerates for every DaemonSet pod.
+var daemonsetDefaultTolerationKeys = map[string]bool{
+	"node.kubernetes.io/not-ready":           true,
+	"node.kubernetes.io/unreachable":         true,
+	"node.kubernetes.io/disk-pressure":       true,
+	"node.kubernetes.io/memory-pressure":     true,
+	"node.kubernetes.io/pid-pressure":        true,
+	"node.kubernetes.io/unschedulable":       true,
+	"node.kubernetes.io/network-unavailable": true,
+}
+
+// parseTolerations parses comma separated tolerations: "*" tolerates every taint, "key", "key=value",
+// "key:Effect" and "key=value:Effect" tolerate matching taints.
+func parseTolerations(value string) ([]corev1.Toleration, error) {
+	var tolerations []corev1.Toleration
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if item == "*" {
+			tolerations = append(tolerations, corev1.Toleration{Operator: corev1.TolerationOpExists})
+			continue
+		}
+
+		toleration := corev1.Toleration{Operator: corev1.TolerationOpExists}
+		if parts := strings.SplitN(item, ":", 2); len(parts) == 2 {
+			item = parts[0]
+			toleration.Effect = corev1.TaintEffect(parts[1])
+			switch toleration.Effect {
+			case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+			default:
+				return nil, fmt.Errorf("unknown taint effect %q", parts[1])
+			}
+		}
+		if parts := strings.SplitN(item, "=", 2); len(parts) == 2 {
+			item = parts[0]
+			toleration.Operator = corev1.TolerationOpEqual
+			toleration.Value = parts[1]
+		}
+		toleration.Key = item
+		tolerations = append(tolerations, toleration)
+	}
+
+	return tolerations, nil
+}
+
+// parseNodeAffinity parses comma separated node requirements: "key=v1|v2" (In), "key!=v1|v2" (NotIn),
+// "key" (Exists) and "!key" (DoesNotExist).
+func parseNodeAffinity(value string) (*corev1.Affinity, error) {
+	var requirements []corev1.NodeSelectorRequirement
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var requirement corev1.NodeSelectorRequirement
+		switch {
+		case strings.Contains(item, "!="):
+			parts := strings.SplitN(item, "!=", 2)
+			requirement = corev1.NodeSelectorRequirement{Key: parts[0], Operator: corev1.NodeSelectorOpNotIn, Values: strings.Split(parts[1], "|")}
+		case strings.Contains(item, "="):
+			parts := strings.SplitN(item, "=", 2)
+			requirement = corev1.NodeSelectorRequirement{Key: parts[0], Operator: corev1.NodeSelectorOpIn, Values: strings.Split(parts[1], "|")}
+		case strings.HasPrefix(item, "!"):
+			requirement = corev1.NodeSelectorRequirement{Key: item[1:], Operator: corev1.NodeSelectorOpDoesNotExist}
+		default:
+			requirement = corev1.NodeSelectorRequirement{Key: item, Operator: corev1.NodeSelectorOpExists}
+		}
+		if requirement.Key == "" {
+			return nil, fmt.Errorf("invalid node affinity requirement %q", item)
+		}
+		requirements = append(requirements, requirement)
+	}
+	if len(requirements) == 0 {
+		return nil, nil
+	}
+
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: requirements}},
+			},
+		},
+	}, nil
+}
+
+func parseResources() (corev1.ResourceRequirements, error) {
+	resources := corev1.ResourceRequirements{}
+	quantities := []struct {
+		value string
+		list  *corev1.ResourceList
+		name  corev1.ResourceName
+	}{
+		{*probeCPURequest, &resources.Requests, corev1.ResourceCPU},
+		{*probeMemoryRequest, &resources.Requests, corev1.ResourceMemory},
+		{*probeCPULimit, &resources.Limits, corev1.ResourceCPU},
+		{*probeMemoryLimit, &resources.Limits, corev1.ResourceMemory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return resources, fmt.Errorf("invalid %s quantity %q: %v", q.name, q.value, err)
+		}
+		if *q.list == nil {
+			*q.list = corev1.ResourceList{}
+		}
+		(*q.list)[q.name] = quantity
+	}
+
+	return resources, nil
+}
+
+// validateProbeSchedulingFlags checks the scheduling flags of probe pods before any pod is created.
+func validateProbeSchedulingFlags() error {
+	if _, err := parseTolerations(*probeTolerations); err != nil {
+		return err
+	}
+	if _, err := parseNodeAffinity(*probeNodeAffinity); err != nil {
+		return err
+	}
+	_, err := parseResources()
+
+	return err
+}
+
+// applyProbeScheduling sets the tolerations, node affinity, priority class and resources given by flags.
+func applyProbeScheduling(spec *corev1.PodSpec) {
+	// the flags are validated in BeforeSuite
+	tolerations, _ := parseTolerations(*probeTolerations)
+	affinity, _ := parseNodeAffinity(*probeNodeAffinity)
+	resources, _ := parseResources()
+
+	spec.Tolerations = append(spec.Tolerations, tolerations...)
+	if affinity != nil && spec.NodeName == "" {
+		spec.Affinity = affinity
+	}
+	spec.PriorityClassName = *probePriorityClass
+	for i := range spec.Containers {
+		spec.Containers[i].Resources = resources
+	}
+}
+
+func matchesNodeSelectorRequirement(node *corev1.Node, requirement corev1.NodeSelectorRequirement) bool {
+	value, exists := node.Labels[requirement.Key]
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpIn:
+		return exists && containsString(requirement.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !exists || !containsString(requirement.Values, value)
+	case corev1.NodeSelectorOpExists:
+		return exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !exists
+	}
+
+	return false
+}
+
+// getUncoveredReason explains why a pod with spec is not running on node.
+func getUncoveredReason(node *corev1.Node, spec *corev1.PodSpec) string {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+			return "node is not ready"
+		}
+	}
+
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil &&
+		spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, requirement := range term.MatchExpressions {
+				if !matchesNodeSelectorRequirement(node, requirement) {
+					return fmt.Sprintf("node does not match node affinity %s %s %v", requirement.Key, requirement.Operator, requirement.Values)
+				}
+			}
+		}
+	}
+
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule || daemonsetDefaultTolerationKeys[taint.Key] {
+			continue
+		}
+		tolerated := false
+		for j := range spec.Tolerations {
+			if spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return fmt.Sprintf("taint %s is not tolerated, see -probe-tolerations", taint.ToString())
+		}
+	}
+
+	return "no probe pod is running on the node"
+}
+
+// checkDaemonsetCoverage records the nodes without a running pod of the DaemonSet, with the reason.
+func checkDaemonsetCoverage(clientset *kubernetes.Clientset, dmsName string, namespace string) {
+	dms, err := clientset.AppsV1().DaemonSets(namespace).Get(dmsName, metav1.GetOptions{})
+	if err != nil {
+		glog.Infof("failed to check coverage of Daemonset %s : %v", dmsName, err)
+		return
+	}
+	nodeList, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Infof("failed to check coverage of Daemonset %s : %v", dmsName, err)
+		return
+	}
+	selector := metav1.FormatLabelSelector(dms.Spec.Selector)
+	podList, err := getPodsWithLabel(clientset, selector, namespace)
+	if err != nil {
+		glog.Infof("failed to check coverage of Daemonset %s : %v", dmsName, err)
+		return
+	}
+
+	coveredNodes := map[string]bool{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			coveredNodes[pod.Spec.NodeName] = true
+		}
+	}
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if coveredNodes[node.Name] {
+			continue
+		}
+		reason := getUncoveredReason(node, &dms.Spec.Template.Spec)
+		glog.Infof("node %s is not covered by Daemonset %s : %s", node.Name, dmsName, reason)
+		recordUncoveredNode(UncoveredNode{Node: node.Name, DaemonSet: dmsName, Reason: reason})
+	}
+}
@@ -0,0 +1,205 @@
+package sntt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+)
+
+const (
+	PodSecurityPrivileged = "privileged"
+	PodSecurityBaseline   = "baseline"
+	PodSecurityRestricted = "restricted"
+
+	PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	PodSecurityWarnLabel    = "pod-security.kubernetes.io/warn"
+
+	// ProbePodPort is the port probe pods answer with their hostname on, used by TCP probes.
+	ProbePodPort = 8080
+	// KubeletPort is used by TCP probes to node IPs.
+	KubeletPort = 10250
+
+	nonRootUserID = 65534
+)
+
+var (
+	downgradeMutex  sync.Mutex
+	probeDowngrades = map[string]bool{}
+)
+
+// getPodSecurityLabels returns the Pod Security Admission labels for namespaces created by sntt.
+func getPodSecurityLabels() map[string]string {
+	return map[string]string{
+		PodSecurityEnforceLabel: *podSecurityLevel,
+		PodSecurityWarnLabel:    *podSecurityLevel,
+	}
+}
+
+func isRestricted() bool {
+	return *podSecurityLevel == PodSecurityRestricted
+}
+
+// isICMPProbeAllowed is false when NET_RAW, which ping needs, is dropped from probe pods.
+func isICMPProbeAllowed() bool {
+	return !isRestricted()
+}
+
+// skipIfHostNetworkIsNotAllowed skips the current test when hostNetwork pods are rejected by the Pod Security level.
+func skipIfHostNetworkIsNotAllowed() {
+	if *podSecurityLevel == PodSecurityPrivileged {
+		return
+	}
+	message := fmt.Sprintf("hostNetwork pods are not allowed with the '%s' Pod Security level", *podSecurityLevel)
+	recordProbeDowngrade(CurrentGinkgoTestDescription().FullTestText + " : skipped, " + message)
+	Skip(message)
+}
+
+func recordProbeDowngrade(description string) {
+	downgradeMutex.Lock()
+	defer downgradeMutex.Unlock()
+
+	probeDowngrades[description] = true
+}
+
+func reportProbeDowngrades() {
+	downgradeMutex.Lock()
+	defer downgradeMutex.Unlock()
+
+	if len(probeDowngrades) == 0 {
+		return
+	}
+	var descriptions []string
+	for description := range probeDowngrades {
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+
+	glog.Infof("========== probes downgraded by the '%s' Pod Security level ==========\n", *podSecurityLevel)
+	for _, description := range descriptions {
+		glog.Info(description)
+	}
+}
+
+// getTCPFallbackPort returns the port TCP probes connect to instead of pinging destination.
+func getTCPFallbackPort(destination string) int {
+	if destination == GoogleIP {
+		return 53
+	}
+	if net.ParseIP(destination) == nil {
+		return 80
+	}
+	if nodes != nil {
+		for i := range nodes.Items {
+			if nodeIP, err := getNodeInternalIP(&nodes.Items[i]); err == nil && nodeIP == destination {
+				return KubeletPort
+			}
+		}
+	}
+
+	return ProbePodPort
+}
+
+// applyPodSecurity sets the security context of probe pods so that they are admitted with the Pod Security level.
+func applyPodSecurity(spec *corev1.PodSpec) {
+	if !isRestricted() {
+		return
+	}
+
+	runAsNonRoot := true
+	runAsUser := int64(nonRootUserID)
+	allowPrivilegeEscalation := false
+	spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsNonRoot: &runAsNonRoot,
+		RunAsUser:    &runAsUser,
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].SecurityContext = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+		}
+	}
+}
+
+// createWithRuntimeDefaultSeccomp creates obj with the RuntimeDefault seccomp profile set in the pod spec at
+// podSpecPath. The vendored API types have no seccompProfile field yet, so the object is posted as raw JSON.
+func createWithRuntimeDefaultSeccomp(restClient restclient.Interface, apiVersion string, kind string, resource string,
+	namespace string, obj runtime.Object, podSpecPath []string, out runtime.Object) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	object["apiVersion"] = apiVersion
+	object["kind"] = kind
+	seccompPath := append(append([]string{}, podSpecPath...), "securityContext", "seccompProfile")
+	err = unstructured.SetNestedField(object, map[string]interface{}{"type": "RuntimeDefault"}, seccompPath...)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	return restClient.Post().Namespace(namespace).Resource(resource).Body(body).Do().Into(out)
+}
+
+func createPod(clientset *kubernetes.Clientset, pod *corev1.Pod) (*corev1.Pod, error) {
+	applyPodSecurity(&pod.Spec)
+	applyProbeScheduling(&pod.Spec)
+	if !isRestricted() {
+		return clientset.CoreV1().Pods(pod.Namespace).Create(pod)
+	}
+
+	podOut := &corev1.Pod{}
+	err := createWithRuntimeDefaultSeccomp(clientset.CoreV1().RESTClient(), "v1", "Pod", "pods", pod.Namespace,
+		pod, []string{"spec"}, podOut)
+
+	return podOut, err
+}
+
+func createDaemonsetObject(clientset *kubernetes.Clientset, dms *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	applyPodSecurity(&dms.Spec.Template.Spec)
+	applyProbeScheduling(&dms.Spec.Template.Spec)
+	if !isRestricted() {
+		return clientset.AppsV1().DaemonSets(dms.Namespace).Create(dms)
+	}
+
+	dmsOut := &appsv1.DaemonSet{}
+	err := createWithRuntimeDefaultSeccomp(clientset.AppsV1().RESTClient(), "apps/v1", "DaemonSet", "daemonsets", dms.Namespace,
+		dms, []string{"spec", "template", "spec"}, dmsOut)
+
+	return dmsOut, err
+}
+
+func createDeploymentObject(clientset *kubernetes.Clientset, deploy *appsv1.Deployment) (*appsv1.Deployment, error) {
+	applyPodSecurity(&deploy.Spec.Template.Spec)
+	applyProbeScheduling(&deploy.Spec.Template.Spec)
+	if !isRestricted() {
+		return clientset.AppsV1().Deployments(deploy.Namespace).Create(deploy)
+	}
+
+	deployOut := &appsv1.Deployment{}
+	err := createWithRuntimeDefaultSeccomp(clientset.AppsV1().RESTClient(), "apps/v1", "Deployment", "deployments", deploy.Namespace,
+		deploy, []string{"spec", "template", "spec"}, deployOut)
+
+	return deployOut, err
+}
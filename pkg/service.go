@@ -0,0 +1,271 @@
+package sntt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkExternalTrafficPolicy deploys echo servers on a subset of nodes behind a NodePort or LoadBalancer service
+// and checks which nodes answer and which client address the echo servers observe.
+//
+// Requests are sent from hostNetwork pods, because kube-proxy treats traffic coming from the pod network as if the
+// service had externalTrafficPolicy=Cluster, which would hide the behaviour of externalTrafficPolicy=Local.
+//
+// Requests from inside the cluster to the load balancer address are short-circuited and masqueraded by kube-proxy, so
+// for LoadBalancer services the client address is asserted on the NodePort the load balancer forwards to, and with
+// externalTrafficPolicy=Local the health check node port must tell the load balancer to skip nodes without endpoints.
+func checkExternalTrafficPolicy(caseName string, svcType corev1.ServiceType,
+	trafficPolicy corev1.ServiceExternalTrafficPolicyType) {
+	skipIfHostNetworkIsNotAllowed()
+
+	echoLabels := map[string]string{"sntt": "echo"}
+	backendNodes := map[string]bool{}
+	var echoPods []*corev1.Pod
+	for _, node := range nodes.Items[:(len(nodes.Items)+1)/2] {
+		echoPod, err := createEchoServerPodInSpecificNode(clientset, "echo-", node.Name, testingNamespace.Name, echoLabels)
+		Expect(err).ToNot(HaveOccurred())
+		glog.Infof("echo server pod %s is created in node %s\n", echoPod.Name, echoPod.Spec.NodeName)
+		backendNodes[node.Name] = true
+		echoPods = append(echoPods, echoPod)
+	}
+
+	svcSpec := makeServiceSpec("echo-", testingNamespace.Name, echoLabels, EchoServerPort, svcType)
+	svcSpec.Spec.ExternalTrafficPolicy = trafficPolicy
+	svc, err := clientset.CoreV1().Services(testingNamespace.Name).Create(svcSpec)
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("Service %s (%s, externalTrafficPolicy=%s) is created\n", svc.Name, svcType, trafficPolicy)
+
+	hostNetworkDms, err := createHostNetworkDaemonset(clientset, PodName2Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	for _, echoPod := range echoPods {
+		err = waitTimeoutForPodStatus(clientset, echoPod.Name, echoPod.Namespace, corev1.PodRunning, timeouts.Provisioning)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, hostNetworkDms.Name, hostNetworkDms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	clientPodList, err := getPodsWithLabel(clientset, "sntt=hostnetwork-daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	nodePort := svc.Spec.Ports[0].NodePort
+	for _, client := range clientPodList.Items {
+		// the address of a hostNetwork pod is the address of its node
+		clientIP, err := getExpectedSourceIP(SNATPolicyNone, &client, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := range nodes.Items {
+			node := &nodes.Items[i]
+			nodeIP, err := getNodeInternalIP(node)
+			Expect(err).ToNot(HaveOccurred())
+			url := fmt.Sprintf("http://%s:%d/clientip", nodeIP, nodePort)
+
+			if node.Name == client.Spec.NodeName {
+				// traffic from the node itself is forwarded to any endpoint by kube-proxy, so nothing to assert
+				glog.Infof("node %s => its own NodePort is not checked\n", client.Spec.NodeName)
+				continue
+			}
+
+			if trafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && !backendNodes[node.Name] {
+				glog.Infof("node %s => %s should be dropped\n", client.Spec.NodeName, url)
+				result := makeProbeResult(caseName, &client, url, node.Name, ProbeKindService)
+				expectProbe(result, consistentlyUnreachable(timeouts.PollingInterval, timeouts.ProbeInterval), func() bool {
+					return isPossibleToRequestFromPodToURL(client.Name, client.Namespace, url, clientset, config)
+				})
+				continue
+			}
+
+			var observedIP string
+			Eventually(func() error {
+				observedIP, err = getObservedClientIPFromPod(client.Name, client.Namespace, url, clientset, config)
+				return err
+			}, timeouts.Probing, timeouts.PollingInterval).Should(Succeed())
+			glog.Infof("node %s => %s is answered, client address is seen as %s\n", client.Spec.NodeName, url, observedIP)
+
+			result := makeProbeResult(caseName, &client, url, node.Name, ProbeKindService)
+			if trafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+				result.Success = observedIP == clientIP
+				result.Expectation = "client address preserved"
+			} else {
+				result.Success = observedIP != clientIP
+				result.Expectation = "client address SNATed"
+			}
+			result.Attempts = 1
+			if result.Success {
+				result.Successes = 1
+			} else {
+				result.Message = fmt.Sprintf("client address %s is seen as %s", clientIP, observedIP)
+			}
+			result.Classification = classify(result.Attempts, result.Successes)
+			recordProbeResult(result)
+			Expect(result.Success).To(BeTrue(), "node %s => %s : %s, expected %s", client.Spec.NodeName, url,
+				result.Message, result.Expectation)
+		}
+	}
+
+	if svcType != corev1.ServiceTypeLoadBalancer {
+		return
+	}
+
+	ingressAddress, err := waitTimeoutForLoadBalancerIngress(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	url := fmt.Sprintf("http://%s:%d/clientip", ingressAddress, EchoServerPort)
+	for _, client := range clientPodList.Items {
+		result := makeNamedProbeResult(caseName, &client, url, EchoServiceName, ProbeKindService)
+		expectProbe(result, getReachableExpectation(), func() bool {
+			return isPossibleToRequestFromPodToURL(client.Name, client.Namespace, url, clientset, config)
+		})
+	}
+
+	if trafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+		return
+	}
+
+	// kube-proxy answers the health check of the load balancer with 503 on nodes without local endpoints
+	Expect(clientPodList.Items).ToNot(BeEmpty(), "no hostNetwork client pod is running")
+	client := clientPodList.Items[0]
+	Expect(svc.Spec.HealthCheckNodePort).ToNot(BeZero(), "service %s has no health check node port", svc.Name)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		nodeIP, err := getNodeInternalIP(node)
+		Expect(err).ToNot(HaveOccurred())
+		healthURL := fmt.Sprintf("http://%s:%d/healthz", nodeIP, svc.Spec.HealthCheckNodePort)
+
+		glog.Infof("health check of node %s (endpoints: %v) => %s\n", node.Name, backendNodes[node.Name], healthURL)
+		expectation := consistentlyUnreachable(timeouts.PollingInterval, timeouts.ProbeInterval)
+		if backendNodes[node.Name] {
+			expectation = getReachableExpectation()
+		}
+		expectProbe(makeProbeResult(caseName, &client, healthURL, node.Name, ProbeKindNode), expectation, func() bool {
+			return isPossibleToRequestFromPodToURL(client.Name, client.Namespace, healthURL, clientset, config)
+		})
+	}
+}
+
+// getHostnameHitsFromPod sends count requests to the '/hostname' url of echo servers from the pod in a single exec
+// and returns how many times each hostname answered, together with the number of failed requests.
+func getHostnameHitsFromPod(podName string, namespace string, url string, count int) (map[string]int, int, error) {
+	return getHostnameHitsFromPodWithHeaders(podName, namespace, url, nil, count)
+}
+
+// getHostnameHitsFromPodWithHeaders is getHostnameHitsFromPod with the headers sent in every request.
+func getHostnameHitsFromPodWithHeaders(podName string, namespace string, url string, headers []string,
+	count int) (map[string]int, int, error) {
+	var headerArgs string
+	for _, header := range headers {
+		headerArgs += fmt.Sprintf(" --header '%s'", header)
+	}
+	script := fmt.Sprintf("for i in $(seq 1 %d); do wget -q -O - -T 2%s %s; echo; done", count, headerArgs, url)
+	stdout, stderr, err := execCommandInPod(podName, namespace, []string{"sh", "-c", script}, clientset, config)
+	if err != nil {
+		return nil, 0, fmt.Errorf("requests from pod %s failed: %v %s", podName, err, stderr)
+	}
+
+	hits := map[string]int{}
+	failures := 0
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	for _, line := range lines {
+		hostname := strings.TrimSpace(line)
+		if hostname == "" {
+			failures++
+			continue
+		}
+		hits[hostname]++
+	}
+
+	return hits, failures, nil
+}
+
+// checkLoadDistribution returns an error when an endpoint received less than minRatio of an even share of the hits.
+func checkLoadDistribution(hits map[string]int, endpoints []string, minRatio float64) error {
+	total := 0
+	for _, count := range hits {
+		total += count
+	}
+	if total == 0 {
+		return fmt.Errorf("no request was answered")
+	}
+
+	evenShare := float64(total) / float64(len(endpoints))
+	var skewed []string
+	for _, endpoint := range endpoints {
+		if float64(hits[endpoint]) < evenShare*minRatio {
+			skewed = append(skewed, fmt.Sprintf("%s=%d", endpoint, hits[endpoint]))
+		}
+	}
+	if len(skewed) > 0 {
+		return fmt.Errorf("distribution is skewed, expected at least %.1f hits per endpoint but got %s",
+			evenShare*minRatio, strings.Join(skewed, ", "))
+	}
+
+	return nil
+}
+
+// checkServiceLoadDistribution puts a ClusterIP service in front of echo server replicas and checks how requests
+// from the probe pod on every node are spread over the endpoints.
+func checkServiceLoadDistribution(sessionAffinity corev1.ServiceAffinity) {
+	echoLabels := map[string]string{"sntt": "echo"}
+	deploy, err := createEchoServerDeployment(clientset, "echo-", testingNamespace.Name, echoLabels, int32(*serviceReplicas))
+	Expect(err).ToNot(HaveOccurred())
+
+	svcSpec := makeServiceSpec("echo-", testingNamespace.Name, echoLabels, EchoServerPort, corev1.ServiceTypeClusterIP)
+	svcSpec.Spec.SessionAffinity = sessionAffinity
+	svc, err := clientset.CoreV1().Services(testingNamespace.Name).Create(svcSpec)
+	Expect(err).ToNot(HaveOccurred())
+	glog.Infof("Service %s (sessionAffinity=%s) is created in front of %d replicas\n", svc.Name, sessionAffinity, *serviceReplicas)
+
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = waitTimeoutForDeploymentReady(clientset, deploy.Name, deploy.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+
+	echoPodList, err := getPodsWithLabel(clientset, "sntt=echo", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	var endpoints []string
+	for _, echoPod := range echoPodList.Items {
+		endpoints = append(endpoints, echoPod.Name)
+	}
+
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	url := fmt.Sprintf("http://%s:%d/hostname", svc.Spec.ClusterIP, EchoServerPort)
+	totalHits := map[string]int{}
+	for _, pod := range podList.Items {
+		hits, failures, err := getHostnameHitsFromPod(pod.Name, pod.Namespace, url, *serviceRequests)
+		Expect(err).ToNot(HaveOccurred())
+		glog.Infof("hits from pod %s in node %s : %v, failures : %d\n", pod.Name, pod.Spec.NodeName, hits, failures)
+		Expect(failures).To(BeZero(), "requests from node %s failed", pod.Spec.NodeName)
+
+		for endpoint, count := range hits {
+			totalHits[endpoint] += count
+		}
+		if sessionAffinity == corev1.ServiceAffinityClientIP {
+			Expect(hits).To(HaveLen(1), "session affinity is not honored for requests from node %s", pod.Spec.NodeName)
+		}
+	}
+
+	glog.Info("========== per-endpoint hit counts ==========\n")
+	for _, endpoint := range endpoints {
+		glog.Infof("%s : %d\n", endpoint, totalHits[endpoint])
+	}
+	for endpoint := range totalHits {
+		Expect(endpoints).To(ContainElement(endpoint), "request answered by unknown endpoint %s", endpoint)
+	}
+
+	if sessionAffinity == corev1.ServiceAffinityNone {
+		Expect(checkLoadDistribution(totalHits, endpoints, *distributionMinRatio)).To(Succeed())
+	}
+}
@@ -1,14 +1,17 @@
 package sntt
 
 import (
+	"fmt"
 	"github.com/golang/glog"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
+	"strings"
 	"time"
 )
 
@@ -20,14 +23,25 @@ const (
 	GoogleDNS = "google.com"
 	GoogleIP  = "8.8.8.8"
 
-	Timeout         = time.Second * 300
-	PollingInterval = time.Second * 10
+	// names of targets in results, as services are created with generated names and addresses differ every run
+	GoogleIPName    = "google-ip"
+	EchoServiceName = "echo-service"
+	WhoamiName      = "whoami"
+
+	EchoServerImage = "registry.k8s.io/e2e-test-images/agnhost:2.39"
+	EchoServerPort  = 8080
+
+	// defaults of -probing-timeout, -teardown-timeout, -polling-interval and -provisioning-timeout
+	Timeout             = time.Second * 300
+	PollingInterval     = time.Second * 10
+	ProvisioningTimeout = time.Second * 30
 )
 
 var (
-	err       error // BeforeEach, AfterEach 때문에 변수로 초기 선언
-	clientset *kubernetes.Clientset
-	config    *restclient.Config
+	err           error // BeforeEach, AfterEach 때문에 변수로 초기 선언
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+	config        *restclient.Config
 
 	defaultNamespaceName = "default"
 	testingNamespace     *corev1.Namespace
@@ -38,9 +52,29 @@ var (
 
 var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 	BeforeSuite(func() {
-		clientset, config = getClientSet()
+		clientset, config, err = getClientSet()
+		Expect(err).ToNot(HaveOccurred())
+		err = validateProbeSchedulingFlags()
+		Expect(err).ToNot(HaveOccurred())
+		err = validateProbeExpectationFlags()
+		Expect(err).ToNot(HaveOccurred())
+		err = validateTimeoutFlags()
+		Expect(err).ToNot(HaveOccurred())
+		err = validateCaseFilters()
+		Expect(err).ToNot(HaveOccurred())
+		glog.Infof("selected cases are %s", strings.Join(getSelectedCaseIDs(), ", "))
+		dynamicClient, err = dynamic.NewForConfig(config)
+		Expect(err).ToNot(HaveOccurred())
 		glog.Info("========== [TEST] End Fetching Current kubernetes client ==========\n")
 
+		if !*skipPreflight {
+			glog.Info("========== [TEST] Start Checking Permissions ==========\n")
+			missing, err := checkRequiredPermissions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(missing).To(BeEmpty(), "missing permissions, run with -print-rbac to get the ClusterRole and Roles to grant")
+			glog.Info("========== [TEST] End Checking Permissions ==========\n")
+		}
+
 		glog.Info("========== [TEST] Start Checking Current Cluster ==========\n")
 		glog.Info("Get the number of nodes")
 		//TODO ready 인 node list 를 받아놓고 name 을 저장하여 추후 pod 생성 시 사용하도록
@@ -55,9 +89,30 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 		glog.Infof("The number of nodes is %d", nodesNum)
 		glog.Info("========== [TEST] End Checking Current Cluster ==========\n")
 	})
+	AfterSuite(func() {
+		reportUncoveredNodes()
+		reportProbeDowngrades()
+		if *resultsFile != "" {
+			err := writeJSONFile(*resultsFile, makeResultsFile())
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("results are written to %s\n", *resultsFile)
+		}
+		if *htmlReport != "" {
+			err := writeHTMLReport(*htmlReport, makeResultsFile())
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("HTML report is written to %s\n", *htmlReport)
+		}
+	})
 	BeforeEach(func() {
 		testCaseNum++
 		glog.Infof("========== [TEST][CASE-#%d] Started ==========\n", testCaseNum)
+		testingNamespace = nil
+		caseID := getCurrentCaseID()
+		skipIfCaseIsNotSelected(caseID)
+		skipAfterInfrastructureError()
+
+		timeouts, err = getCaseTimeouts(caseID)
+		Expect(err).ToNot(HaveOccurred())
 
 		// create testing namespace
 		testingNamespace, err = createNamespace(clientset, makeNamespaceSpec(NamespacePrefix))
@@ -65,32 +120,41 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 		glog.Infof("Namespace %s is created\n", testingNamespace.Name)
 	})
 	AfterEach(func() {
+		if testingNamespace == nil {
+			return
+		}
 		err := clientset.CoreV1().Namespaces().Delete(testingNamespace.Name, &metav1.DeleteOptions{})
 		Expect(err).ToNot(HaveOccurred())
-		Eventually(func() bool {
-			ns, err := clientset.CoreV1().Namespaces().Get(testingNamespace.Name, metav1.GetOptions{})
-			if err != nil || errors.IsNotFound(err) {
-				return true
-			}
-
-			if ns.Status.Phase == corev1.NamespaceTerminating {
-				glog.Infof("Namespace %s is still in phase %s\n", testingNamespace.Name, ns.Status.Phase)
-				return false
-			}
-			return false
-		}, Timeout, PollingInterval).Should(BeTrue())
+		err = waitTimeoutForNamespaceDeleted(clientset, testingNamespace.Name, timeouts.Teardown)
+		Expect(err).ToNot(HaveOccurred())
 	})
 
 	// TODO Tests Cases :
 	// node 개수 n 일 때,
 	// O case A) (같은 노드 같은 ns), (다른 노드 같은 ns), (같은 노드 다른 ns), (다른 노드 다른 ns) 사이 : 4 개
 	// O case B) (노드 1에서 외부망), (노드 2에서 외부망), (노드 3), ... 에서 외부망(google.com, 8.8.8.8) : 1 개 - daemonset 으로 다 띄워놓고 통신
+	// O case B-2) 각 노드에서 외부망으로 나갈 때 보이는 source IP (SNAT) 확인 : 1 개 - whoami endpoint 사용
 	// O case C) (임의의 노드 default ns 에서 임의의 노드 custom ns) 사이 : 1 개 - NetworkPolicy on default namespace
 	// O case D) 임의의 노드 default ns 에서 외부망(google.com, 8.8.8.8) : 1 개 - NetworkPolicy on default namespace
+	// O case E) hostNetwork pod 및 node 를 거치는 경로 : (pod => node IP), (node => pod IP), (hostNetwork pod => ClusterIP) 각 노드마다
+	// O case F) NodePort, LoadBalancer service 의 externalTrafficPolicy (Cluster, Local) 별로 응답하는 노드와 client IP 보존 여부
+	// O case G) ClusterIP service 뒤의 replica 들로 요청이 분산되는지, sessionAffinity=ClientIP 가 지켜지는지
+	// O case H) endpoint 가 추가/제거 되었을 때 각 노드에 반영되기까지 걸리는 시간 (-measure-endpoint-propagation)
+	// O case I) Ingress 의 host routing, TLS termination, 일치하지 않는 host 에 대한 404
+	// O case J) Gateway API HTTPRoute 의 header routing, weighted backend, path rewrite (CRD, GatewayClass 가 없으면 skip)
+	// O case K) 여러 cluster (kubeconfig context) 사이의 pod, service 통신 (-cluster-contexts)
+	// O case L) zone (또는 -topology-key) 내부/사이의 pod 통신과 latency : zone pair 별로 집계
+	// O case M) pod 를 노드마다 돌아가며 삭제/재생성 : 재사용된 pod IP 로의 요청이 새 pod 에 도달하는지 (stale route, ARP)
+	// O case N) CNI pod 재시작, node drain 중에도 노드 사이 pod 통신이 유지/복구되는지 (-confirm-disruptive)
+	// O case O) ClusterIP service 를 거치는 오래 유지되는 TCP 연결이 endpoint, port 변경 중에도 끊기지 않는지
+	// O case P) 각 노드에서 service, pod IP 로 초당 많은 짧은 TCP 연결 : 성공률, 연결 latency, EADDRNOTAVAIL 등 (-stress-rate)
+	// O case Q) 각 노드에서 DNS 성능 : QPS, p50/p99 latency, SERVFAIL/timeout 비율, ndots search 확장 비용 (resolver 별)
+	// O case R) 각 노드의 pod 가 사용하는 resolver (kube-dns, NodeLocal DNSCache) 와 CoreDNS stub domain, forwarder 확인
+	// O case S) 주어진 namespace 들의 NetworkPolicy 로 계산한 허용/차단 표와 실제 pod 사이 통신 비교 (-policy-namespaces)
 
 	//TODO A) 를 daemonset 으로 생성해서 한 번에 테스트하도록 변경 - 2n 개 pod 띄워놓고 2nC2 번 테스트
 	// case A-1
-	Describe("Test Pod Network In the same Namespace and same Node", func() {
+	Describe("[A-1] Test Pod Network In the same Namespace and same Node", func() {
 		It("Check ping between pods in the same namespace by ip address", func() {
 			pod1, err := createPodInSpecificNode(clientset, PodName1Prefix, nodes.Items[0].Name, testingNamespace.Name)
 			Expect(err).ToNot(HaveOccurred())
@@ -100,9 +164,9 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("pod %s is created in node %s\n", pod2.Name, pod2.Spec.NodeName)
 
-			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
-			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 
 			pod1IP, err := getPodIP(clientset, pod1.Name, testingNamespace.Name)
@@ -114,18 +178,18 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			glog.Infof("IP of pod_2 is %s\n", pod2IP)
 
 			// check ping each other
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-1", pod1, pod2IP, pod2.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod1.Name, testingNamespace.Name, pod2IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-1", pod2, pod1IP, pod1.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod2.Name, testingNamespace.Name, pod1IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 		})
 	})
 
 	// case A-2
-	Describe("Test Pod Network In the same Namespace and different Nodes", func() {
+	Describe("[A-2] Test Pod Network In the same Namespace and different Nodes", func() {
 		It("Check ping between pods in the same namespace by ip address", func() {
 			pod1, err := createPodInSpecificNode(clientset, PodName1Prefix, nodes.Items[0].Name, testingNamespace.Name)
 			Expect(err).ToNot(HaveOccurred())
@@ -135,9 +199,9 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("pod %s is created in node %s\n", pod2.Name, pod2.Spec.NodeName)
 
-			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
-			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 
 			pod1IP, err := getPodIP(clientset, pod1.Name, testingNamespace.Name)
@@ -149,18 +213,18 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			glog.Infof("IP of pod_2 is %s\n", pod2IP)
 
 			// check ping each other
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-2", pod1, pod2IP, pod2.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod1.Name, testingNamespace.Name, pod2IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-2", pod2, pod1IP, pod1.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod2.Name, testingNamespace.Name, pod1IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 		})
 	})
 
 	// case A-3)
-	Describe("Test Pod Network Between the different Namespaces and same Node", func() {
+	Describe("[A-3] Test Pod Network Between the different Namespaces and same Node", func() {
 		It("Check ping between pods in the different namespaces by ip address", func() {
 			pod1, err := createPodInSpecificNode(clientset, PodName1Prefix, nodes.Items[0].Name, testingNamespace.Name)
 			Expect(err).ToNot(HaveOccurred())
@@ -175,9 +239,9 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("pod %s is created in node %s\n", pod2.Name, pod2.Spec.NodeName)
 
-			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
-			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 
 			pod1IP, err := getPodIP(clientset, pod1.Name, testingNamespace.Name)
@@ -188,34 +252,24 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			glog.Infof("IP of pod_1 is %s\n", pod1IP)
 			glog.Infof("IP of pod_2 is %s\n", pod2IP)
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-3", pod1, pod2IP, pod2.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod1.Name, testingNamespace.Name, pod2IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-3", pod2, pod1IP, pod1.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod2.Name, anotherNamespace.Name, pod1IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
 			// TODO must Delete another namespace
 			err = clientset.CoreV1().Namespaces().Delete(anotherNamespace.Name, &metav1.DeleteOptions{})
 			Expect(err).ToNot(HaveOccurred())
-			Eventually(func() bool {
-				ns, err := clientset.CoreV1().Namespaces().Get(anotherNamespace.Name, metav1.GetOptions{})
-				if err != nil || errors.IsNotFound(err) {
-					return true
-				}
-
-				if ns.Status.Phase == corev1.NamespaceTerminating {
-					glog.Infof("Namespace %s is still in phase %s\n", anotherNamespace.Name, ns.Status.Phase)
-					return false
-				}
-				return false
-			}, Timeout, PollingInterval).Should(BeTrue())
+			err = waitTimeoutForNamespaceDeleted(clientset, anotherNamespace.Name, timeouts.Teardown)
+			Expect(err).ToNot(HaveOccurred())
 		})
 	})
 
 	// case A-4)
-	Describe("Test Pod Network Between the different Namespaces and different Nodes", func() {
+	Describe("[A-4] Test Pod Network Between the different Namespaces and different Nodes", func() {
 		It("Check ping between pods in the different namespaces by ip address", func() {
 			pod1, err := createPodInSpecificNode(clientset, PodName1Prefix, nodes.Items[0].Name, testingNamespace.Name)
 			Expect(err).ToNot(HaveOccurred())
@@ -230,9 +284,9 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("pod %s is created in node %s\n", pod2.Name, pod2.Spec.NodeName)
 
-			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
-			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod2.Name, pod2.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 
 			pod1IP, err := getPodIP(clientset, pod1.Name, testingNamespace.Name)
@@ -243,40 +297,30 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			glog.Infof("IP of pod_1 is %s\n", pod1IP)
 			glog.Infof("IP of pod_2 is %s\n", pod2IP)
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-4", pod1, pod2IP, pod2.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod1.Name, testingNamespace.Name, pod2IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("A-4", pod2, pod1IP, pod1.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod2.Name, anotherNamespace.Name, pod1IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
 			// TODO must Delete another namespace
 			err = clientset.CoreV1().Namespaces().Delete(anotherNamespace.Name, &metav1.DeleteOptions{})
 			Expect(err).ToNot(HaveOccurred())
-			Eventually(func() bool {
-				ns, err := clientset.CoreV1().Namespaces().Get(anotherNamespace.Name, metav1.GetOptions{})
-				if err != nil || errors.IsNotFound(err) {
-					return true
-				}
-
-				if ns.Status.Phase == corev1.NamespaceTerminating {
-					glog.Infof("Namespace %s is still in phase %s\n", anotherNamespace.Name, ns.Status.Phase)
-					return false
-				}
-				return false
-			}, Timeout, PollingInterval).Should(BeTrue())
+			err = waitTimeoutForNamespaceDeleted(clientset, anotherNamespace.Name, timeouts.Teardown)
+			Expect(err).ToNot(HaveOccurred())
 		})
 	})
 
 	// case B) 각 노드에서 외부망으로 통신 확인 (google.com, 8.8.8.8) : 1 개
-	Describe("Test Pod Network From each node in 'custom' namespace To external server", func() {
+	Describe("[B] Test Pod Network From each node in 'custom' namespace To external server", func() {
 		It("Check ping to 'google.com' & '8.8.8.8'", func() {
 			dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("Daemonset %s is creating \n", dms.Name)
 
-			err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, time.Second*30)
+			err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 			time.Sleep(5 * time.Second) //TODO need to fix with daemonset ready
 			glog.Infof("Daemonset %s is created \n", dms.Name)
@@ -293,12 +337,12 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 				Expect(err).ToNot(HaveOccurred())
 				glog.Infof("IP of pod %d is %s\n", i+1, podIP)
 
-				Eventually(func() bool {
+				expectProbe(makeNamedProbeResult("B", &pod, GoogleDNS, GoogleDNS, ProbeKindExternal), getReachableExpectation(), func() bool {
 					return isPossibleToPingFromPodToIP(pod.Name, testingNamespace.Name, GoogleDNS, clientset, config)
-				}, Timeout, PollingInterval).Should(BeTrue())
-				Eventually(func() bool {
+				})
+				expectProbe(makeNamedProbeResult("B", &pod, GoogleIP, GoogleIPName, ProbeKindExternal), getReachableExpectation(), func() bool {
 					return isPossibleToPingFromPodToIP(pod.Name, testingNamespace.Name, GoogleIP, clientset, config)
-				}, Timeout, PollingInterval).Should(BeTrue())
+				})
 			}
 
 			// Delete daemonset
@@ -312,12 +356,89 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 				}
 				glog.Infof("Daemonset %s is still Terminating \n", dms.Name)
 				return false
-			}, Timeout, PollingInterval).Should(BeTrue())
+			}, timeouts.Teardown, timeouts.PollingInterval).Should(BeTrue())
+		})
+	})
+
+	// case B-2) 각 노드에서 외부망으로 나갈 때의 source IP 가 SNAT 정책과 일치하는지 확인
+	Describe("[B-2] Test egress source IP From each node in 'custom' namespace To whoami endpoint", func() {
+		It("Check the client address observed by the whoami endpoint matches the SNAT policy", func() {
+			policy := *snatPolicy
+			whoamiURL := *snatWhoamiURL
+			if whoamiURL == "" {
+				// whoami endpoint 가 주어지지 않으면 echo server pod 를 대신 사용
+				echoPod, err := createEchoServerPodInSpecificNode(clientset, "whoami-", nodes.Items[0].Name, testingNamespace.Name,
+					map[string]string{"sntt": "whoami"})
+				Expect(err).ToNot(HaveOccurred())
+				err = waitTimeoutForPodStatus(clientset, echoPod.Name, echoPod.Namespace, corev1.PodRunning, timeouts.Provisioning)
+				Expect(infrastructureError(err)).ToNot(HaveOccurred())
+
+				echoPodIP, err := getPodIP(clientset, echoPod.Name, testingNamespace.Name)
+				Expect(err).ToNot(HaveOccurred())
+				whoamiURL = fmt.Sprintf("http://%s:%d/clientip", echoPodIP, EchoServerPort)
+				if policy == SNATPolicyAuto {
+					policy = SNATPolicyNone
+				}
+			} else if policy == SNATPolicyAuto {
+				// 기대하는 source IP 가 주어지지 않으면 같은 노드의 hostNetwork pod 가 보이는 주소와 비교
+				skipIfHostNetworkIsNotAllowed()
+			}
+			glog.Infof("whoami endpoint is %s, expected SNAT policy is '%s'\n", whoamiURL, policy)
+
+			dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+			err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+
+			podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+
+			nodeSourceIPs := map[string]string{}
+			if policy == SNATPolicyAuto {
+				hostNetworkDms, err := createHostNetworkDaemonset(clientset, PodName2Prefix, testingNamespace.Name)
+				Expect(err).ToNot(HaveOccurred())
+				err = waitTimeoutForDaemonsetReady(clientset, hostNetworkDms.Name, hostNetworkDms.Namespace, timeouts.Provisioning)
+				Expect(err).ToNot(HaveOccurred())
+
+				hostNetworkPodList, err := getPodsWithLabel(clientset, "sntt=hostnetwork-daemonset", testingNamespace.Name)
+				Expect(err).ToNot(HaveOccurred())
+				for _, pod := range hostNetworkPodList.Items {
+					var nodeSourceIP string
+					Eventually(func() error {
+						nodeSourceIP, err = getObservedClientIPFromPod(pod.Name, testingNamespace.Name, whoamiURL, clientset, config)
+						return err
+					}, timeouts.Probing, timeouts.PollingInterval).Should(Succeed())
+					glog.Infof("node %s (%s) is seen as %s\n", pod.Spec.NodeName, pod.Status.HostIP, nodeSourceIP)
+					nodeSourceIPs[pod.Spec.NodeName] = nodeSourceIP
+				}
+			}
+
+			for i := range podList.Items {
+				pod := &podList.Items[i]
+				expectedIP, found := nodeSourceIPs[pod.Spec.NodeName]
+				if policy != SNATPolicyAuto {
+					expectedIP, err = getExpectedSourceIP(policy, pod, *snatEgressIP)
+					Expect(err).ToNot(HaveOccurred())
+				} else {
+					Expect(found).To(BeTrue(), "no hostNetwork pod is seen from node %s", pod.Spec.NodeName)
+				}
+
+				expectProbe(makeNamedProbeResult("B-2", pod, whoamiURL, WhoamiName, ProbeKindExternal), getReachableExpectation(), func() bool {
+					observedIP, err := getObservedClientIPFromPod(pod.Name, testingNamespace.Name, whoamiURL, clientset, config)
+					if err != nil {
+						glog.Info(err)
+						return false
+					}
+					glog.Infof("pod %s (%s) in node %s (%s) is seen as %s, expected %s\n", pod.Name, pod.Status.PodIP,
+						pod.Spec.NodeName, pod.Status.HostIP, observedIP, expectedIP)
+					return observedIP == expectedIP
+				})
+			}
 		})
 	})
 
 	// case C) (임의의 노드 default ns 에서 임의의 노드 custom ns) 사이 : 1 개
-	Describe("Test Pod Network From default ns To custom ns", func() {
+	Describe("[C] Test Pod Network From default ns To custom ns", func() {
 		It("Check ping from default namespaced pod to another namespaced pod", func() {
 			defaultNamespacedPod, err := createPodInRandomNode(clientset, "default-ns-"+PodName2Prefix, defaultNamespaceName)
 			Expect(err).ToNot(HaveOccurred())
@@ -327,9 +448,9 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("pod %s is created in node %s\n", pod1.Name, pod1.Spec.NodeName)
 
-			err = waitTimeoutForPodStatus(clientset, defaultNamespacedPod.Name, defaultNamespacedPod.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, defaultNamespacedPod.Name, defaultNamespacedPod.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
-			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, pod1.Name, pod1.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 
 			defaultNamespacedPodIP, err := getPodIP(clientset, defaultNamespacedPod.Name, defaultNamespaceName)
@@ -340,12 +461,12 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("IP of pod_1 is %s\n", pod1IP)
 
-			Eventually(func() bool {
+			expectProbe(makeProbeResult("C", defaultNamespacedPod, pod1IP, pod1.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(defaultNamespacedPod.Name, defaultNamespaceName, pod1IP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
-			Eventually(func() bool {
+			})
+			expectProbe(makeProbeResult("C", pod1, defaultNamespacedPodIP, defaultNamespacedPod.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(pod1.Name, pod1.Namespace, defaultNamespacedPodIP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
 			// TODO must Delete default ns pod but my harmful
 			err = clientset.CoreV1().Pods(defaultNamespaceName).Delete(defaultNamespacedPod.Name, &metav1.DeleteOptions{})
@@ -361,18 +482,18 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 					return false
 				}
 				return false
-			}, Timeout, PollingInterval).Should(BeTrue())
+			}, timeouts.Teardown, timeouts.PollingInterval).Should(BeTrue())
 		})
 	})
 
 	// case D-1 (임의의 노드 default ns 에서 외부망으로)
-	Describe("Test Pod Network From each node in 'default' namespace To external server", func() {
-		It("Check ping to 'google.com' & '8.8.8.8'. You may need to check /etc/resolve.conf if this test failed", func() {
+	Describe("[D-1] Test Pod Network From each node in 'default' namespace To external server", func() {
+		It("Check ping to 'google.com' & '8.8.8.8'. Case R checks the resolver of /etc/resolv.conf if this test failed", func() {
 			defaultNamespacedPod, err := createPodInRandomNode(clientset, "default-ns-"+PodName2Prefix, defaultNamespaceName)
 			Expect(err).ToNot(HaveOccurred())
 			glog.Infof("pod %s is created in node %s\n", defaultNamespacedPod.Name, defaultNamespacedPod.Spec.NodeName)
 
-			err = waitTimeoutForPodStatus(clientset, defaultNamespacedPod.Name, defaultNamespacedPod.Namespace, corev1.PodRunning, time.Second*30)
+			err = waitTimeoutForPodStatus(clientset, defaultNamespacedPod.Name, defaultNamespacedPod.Namespace, corev1.PodRunning, timeouts.Provisioning)
 			Expect(err).ToNot(HaveOccurred())
 
 			testingPod, err := getPodIP(clientset, defaultNamespacedPod.Name, defaultNamespaceName)
@@ -380,12 +501,12 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 
 			glog.Infof("IP of testingPod is %s\n", testingPod)
 
-			Eventually(func() bool {
+			expectProbe(makeNamedProbeResult("D-1", defaultNamespacedPod, GoogleDNS, GoogleDNS, ProbeKindExternal), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(defaultNamespacedPod.Name, defaultNamespaceName, GoogleDNS, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
-			Eventually(func() bool {
+			})
+			expectProbe(makeNamedProbeResult("D-1", defaultNamespacedPod, GoogleIP, GoogleIPName, ProbeKindExternal), getReachableExpectation(), func() bool {
 				return isPossibleToPingFromPodToIP(defaultNamespacedPod.Name, defaultNamespaceName, GoogleIP, clientset, config)
-			}, Timeout, PollingInterval).Should(BeTrue())
+			})
 
 			// TODO must Delete default ns pod but my harmful
 			err = clientset.CoreV1().Pods(defaultNamespaceName).Delete(defaultNamespacedPod.Name, &metav1.DeleteOptions{})
@@ -401,7 +522,293 @@ var _ = Describe("SIMPLE NETWORK TESTING TOOL", func() {
 					return false
 				}
 				return false
-			}, Timeout, PollingInterval).Should(BeTrue())
+			}, timeouts.Teardown, timeouts.PollingInterval).Should(BeTrue())
+		})
+	})
+
+	// case E-1) 각 노드의 pod 에서 모든 node IP 로
+	Describe("[E-1] Test Pod Network From each node To every node IP", func() {
+		It("Check ping from pods on the pod network to the InternalIP of every node", func() {
+			dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("Daemonset %s is creating \n", dms.Name)
+
+			err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("Daemonset %s is created \n", dms.Name)
+
+			podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, pod := range podList.Items {
+				for i := range nodes.Items {
+					nodeIP, err := getNodeInternalIP(&nodes.Items[i])
+					Expect(err).ToNot(HaveOccurred())
+					glog.Infof("ping from pod %s in node %s to node %s (%s)\n", pod.Name, pod.Spec.NodeName, nodes.Items[i].Name, nodeIP)
+
+					expectProbe(makeProbeResult("E-1", &pod, nodeIP, nodes.Items[i].Name, ProbeKindNode), getReachableExpectation(), func() bool {
+						return isPossibleToPingFromPodToIP(pod.Name, testingNamespace.Name, nodeIP, clientset, config)
+					})
+				}
+			}
+		})
+	})
+
+	// case E-2) 각 노드(hostNetwork pod) 에서 모든 노드의 pod IP 로
+	Describe("[E-2] Test Pod Network From each node To pods on every node", func() {
+		It("Check ping from hostNetwork pods to the pod IP of a pod on every node", func() {
+			skipIfHostNetworkIsNotAllowed()
+
+			dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+			hostNetworkDms, err := createHostNetworkDaemonset(clientset, PodName2Prefix, testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("Daemonset %s and %s are creating \n", dms.Name, hostNetworkDms.Name)
+
+			err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+			err = waitTimeoutForDaemonsetReady(clientset, hostNetworkDms.Name, hostNetworkDms.Namespace, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("Daemonset %s and %s are created \n", dms.Name, hostNetworkDms.Name)
+
+			podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+			hostNetworkPodList, err := getPodsWithLabel(clientset, "sntt=hostnetwork-daemonset", testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, hostNetworkPod := range hostNetworkPodList.Items {
+				for _, pod := range podList.Items {
+					podIP, err := getPodIP(clientset, pod.Name, testingNamespace.Name)
+					Expect(err).ToNot(HaveOccurred())
+					glog.Infof("ping from node %s to pod %s (%s) in node %s\n", hostNetworkPod.Spec.NodeName, pod.Name, podIP, pod.Spec.NodeName)
+
+					expectProbe(makeProbeResult("E-2", &hostNetworkPod, podIP, pod.Spec.NodeName, ProbeKindPod), getReachableExpectation(), func() bool {
+						return isPossibleToPingFromPodToIP(hostNetworkPod.Name, testingNamespace.Name, podIP, clientset, config)
+					})
+				}
+			}
+		})
+	})
+
+	// case E-3) 각 노드의 hostNetwork pod 에서 ClusterIP service 로
+	Describe("[E-3] Test Service Network From each node To ClusterIP service", func() {
+		It("Check http request from hostNetwork pods to a ClusterIP service", func() {
+			skipIfHostNetworkIsNotAllowed()
+
+			echoLabels := map[string]string{"sntt": "echo"}
+			echoPod, err := createEchoServerPodInSpecificNode(clientset, PodName1Prefix, nodes.Items[0].Name, testingNamespace.Name, echoLabels)
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("echo server pod %s is created in node %s\n", echoPod.Name, echoPod.Spec.NodeName)
+
+			svc, err := createService(clientset, PodName1Prefix, testingNamespace.Name, echoLabels, EchoServerPort, corev1.ServiceTypeClusterIP)
+			Expect(err).ToNot(HaveOccurred())
+			glog.Infof("Service %s is created with ClusterIP %s\n", svc.Name, svc.Spec.ClusterIP)
+
+			hostNetworkDms, err := createHostNetworkDaemonset(clientset, PodName2Prefix, testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = waitTimeoutForPodStatus(clientset, echoPod.Name, echoPod.Namespace, corev1.PodRunning, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+			err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+			err = waitTimeoutForDaemonsetReady(clientset, hostNetworkDms.Name, hostNetworkDms.Namespace, timeouts.Provisioning)
+			Expect(err).ToNot(HaveOccurred())
+
+			hostNetworkPodList, err := getPodsWithLabel(clientset, "sntt=hostnetwork-daemonset", testingNamespace.Name)
+			Expect(err).ToNot(HaveOccurred())
+
+			url := fmt.Sprintf("http://%s:%d/hostname", svc.Spec.ClusterIP, EchoServerPort)
+			for _, hostNetworkPod := range hostNetworkPodList.Items {
+				glog.Infof("request from node %s to %s\n", hostNetworkPod.Spec.NodeName, url)
+
+				expectProbe(makeNamedProbeResult("E-3", &hostNetworkPod, url, EchoServiceName, ProbeKindService), getReachableExpectation(), func() bool {
+					return isPossibleToRequestFromPodToURL(hostNetworkPod.Name, testingNamespace.Name, url, clientset, config)
+				})
+			}
+		})
+	})
+
+	// case F-1) NodePort, externalTrafficPolicy=Cluster : 모든 노드가 응답
+	Describe("[F-1] Test NodePort service with externalTrafficPolicy=Cluster From each node", func() {
+		It("Check every node answers on the NodePort and the client address is SNATed", func() {
+			checkExternalTrafficPolicy("F-1", corev1.ServiceTypeNodePort, corev1.ServiceExternalTrafficPolicyTypeCluster)
+		})
+	})
+
+	// case F-2) NodePort, externalTrafficPolicy=Local : endpoint 가 있는 노드만 응답하고 client IP 가 보존됨
+	Describe("[F-2] Test NodePort service with externalTrafficPolicy=Local From each node", func() {
+		It("Check only nodes with endpoints answer on the NodePort and the client address is preserved", func() {
+			checkExternalTrafficPolicy("F-2", corev1.ServiceTypeNodePort, corev1.ServiceExternalTrafficPolicyTypeLocal)
+		})
+	})
+
+	// case F-3) LoadBalancer, externalTrafficPolicy=Cluster
+	Describe("[F-3] Test LoadBalancer service with externalTrafficPolicy=Cluster From each node", func() {
+		BeforeEach(func() {
+			if !*testLoadBalancer {
+				Skip("LoadBalancer service is tested only with -test-loadbalancer")
+			}
+		})
+
+		It("Check the load balancer answers with externalTrafficPolicy=Cluster", func() {
+			checkExternalTrafficPolicy("F-3", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeCluster)
+		})
+	})
+
+	// case F-4) LoadBalancer, externalTrafficPolicy=Local
+	Describe("[F-4] Test LoadBalancer service with externalTrafficPolicy=Local From each node", func() {
+		BeforeEach(func() {
+			if !*testLoadBalancer {
+				Skip("LoadBalancer service is tested only with -test-loadbalancer")
+			}
+		})
+
+		It("Check the load balancer answers with externalTrafficPolicy=Local", func() {
+			checkExternalTrafficPolicy("F-4", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeLocal)
+		})
+	})
+
+	// case G-1) sessionAffinity 없이 endpoint 들로 고르게 분산되는지
+	Describe("[G-1] Test load distribution of ClusterIP service From each node", func() {
+		It("Check requests are spread over every endpoint without session affinity", func() {
+			checkServiceLoadDistribution(corev1.ServiceAffinityNone)
+		})
+	})
+
+	// case G-2) sessionAffinity=ClientIP 일 때 client 마다 하나의 endpoint 로만 가는지
+	Describe("[G-2] Test session affinity of ClusterIP service From each node", func() {
+		It("Check requests from each client stick to one endpoint with sessionAffinity=ClientIP", func() {
+			checkServiceLoadDistribution(corev1.ServiceAffinityClientIP)
+		})
+	})
+
+	// case H) EndpointSlice 변경이 각 노드로 전파되는 시간 측정
+	Describe("[H] Test endpoint propagation latency of ClusterIP service To each node", func() {
+		BeforeEach(func() {
+			if !*measureEndpointPropagation {
+				Skip("endpoint propagation latency is measured only with -measure-endpoint-propagation")
+			}
+		})
+
+		It("Measure how long it takes until every node routes to added endpoints and stops routing to removed ones", func() {
+			checkEndpointPropagation()
+		})
+	})
+
+	// case I) 각 노드의 pod 에서 ingress controller 를 통해 backend 로
+	Describe("[I] Test Ingress From each node To backend service", func() {
+		It("Check host and path routing, TLS termination and 404 for unmatched hosts and paths through the ingress controller", func() {
+			checkIngress()
+		})
+	})
+
+	// case J) 각 노드의 pod 에서 Gateway 를 통해 backend 로
+	Describe("[J] Test Gateway API HTTPRoute From each node To backend services", func() {
+		It("Check header based routing, weighted backends and path rewrites through the Gateway", func() {
+			checkGatewayAPI()
+		})
+	})
+
+	// case K) cluster 간 pod, service 통신
+	Describe("[K] Test Pod and Service Network across clusters", func() {
+		BeforeEach(func() {
+			if *clusterContexts == "" {
+				Skip("multi-cluster connectivity is tested only with -cluster-contexts")
+			}
+		})
+
+		It("Check reachability matrix of pods and services across every pair of clusters", func() {
+			checkMultiClusterConnectivity()
+		})
+	})
+
+	// case L) topology (zone) 별 pod 통신과 latency
+	Describe("[L] Test Pod Network within and across topology zones", func() {
+		It("Check ping between pods of every pair of nodes and aggregate latency by zone pair", func() {
+			checkTopologyMatrix()
+		})
+	})
+
+	// case M) pod 삭제/재생성 반복 중 재사용된 pod IP 가 새 pod 로 전달되는지
+	Describe("[M] Test Pod Network under pod churn across nodes", func() {
+		It("Check requests to every recreated pod IP, reused ones in particular, reach the pod which holds it from every node", func() {
+			checkPodChurn()
+		})
+	})
+
+	// case N) CNI DaemonSet pod 재시작, node cordon/drain 중 노드 사이 pod 통신의 downtime
+	Describe("[N] Test Pod Network during CNI agent restarts and node drains", func() {
+		BeforeEach(func() {
+			skipUnlessDisruptionIsConfirmed()
+		})
+
+		It("Check flows between pods on different nodes recover while the CNI pods are restarted one node at a time", func() {
+			checkConnectivityDuringDisruption(restartCNIPods)
+		})
+
+		It("Check flows between pods on different nodes recover while a node is cordoned and drained", func() {
+			if *drainNodeName == "" {
+				Skip("node drain is tested only with -drain-node")
+			}
+			defer func() {
+				err := setNodeUnschedulable(*drainNodeName, false)
+				Expect(err).ToNot(HaveOccurred())
+				glog.Infof("node %s is uncordoned\n", *drainNodeName)
+			}()
+			checkConnectivityDuringDisruption(func() {
+				drainNode(*drainNodeName)
+			})
+		})
+	})
+
+	// case O) service 변경 중 오래 유지되는 TCP 연결
+	Describe("[O] Test long-lived TCP connections From each node To ClusterIP service", func() {
+		BeforeEach(func() {
+			skipUnlessLongLivedConnectionsAreEnabled()
+		})
+
+		It("Check connections with heartbeats survive adding and removing endpoints and updating ports of the service", func() {
+			checkLongLivedConnections()
+		})
+	})
+
+	// case P) 짧은 TCP 연결을 많이 만들어 conntrack, SNAT port 고갈 확인
+	Describe("[P] Test connection rate From each node To ClusterIP service and pod on the next node", func() {
+		BeforeEach(func() {
+			skipUnlessStressIsEnabled()
+		})
+
+		It("Check the success rate and connect latency of short-lived connections opened at -stress-rate", func() {
+			checkConnectionRate()
+		})
+	})
+
+	// case Q) 각 노드에서 resolver 별 DNS latency, 실패율
+	Describe("[Q] Test DNS performance From each node To cluster DNS and its replicas", func() {
+		It("Check latency, failure rate and search expansion of A and AAAA lookups of short and fully qualified names", func() {
+			checkDNSPerformance()
+		})
+	})
+
+	// case R) /etc/resolv.conf 의 resolver 와 CoreDNS ConfigMap 의 stub domain, forwarder
+	Describe("[R] Test DNS resolver path From each node To kube-dns or NodeLocal DNSCache and upstream forwarders", func() {
+		It("Check pods use the expected resolver in /etc/resolv.conf and that it answers on every node", func() {
+			checkResolverPath()
+		})
+
+		It("Check stub domains and upstream forwarders of the CoreDNS ConfigMap resolve as configured", func() {
+			checkCorefileForwarders()
+		})
+	})
+
+	// case S) NetworkPolicy 로 계산한 허용/차단 표와 실제 통신 비교
+	Describe("[S] Test NetworkPolicy conformance Between probe pods in the given namespaces", func() {
+		BeforeEach(func() {
+			skipUnlessPolicyNamespacesAreGiven()
+		})
+
+		It("Check every connection between probe pods is allowed or denied as computed from the NetworkPolicies", func() {
+			checkPolicyMatrix()
 		})
 	})
 })
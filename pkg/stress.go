@@ -0,0 +1,289 @@
+package sntt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	StressCase = "P"
+
+	// StressRequestTimeout is the timeout in seconds of every short-lived connection.
+	StressRequestTimeout = 2
+
+	ErrorAddressNotAvailable = "EADDRNOTAVAIL"
+	ErrorTimeout             = "timeout"
+	ErrorRefused             = "refused"
+	ErrorReset               = "reset"
+	ErrorUnreachable         = "unreachable"
+	ErrorOther               = "other"
+)
+
+func skipUnlessStressIsEnabled() {
+	if *stressRate <= 0 {
+		Skip("connection-rate stress test is run only with -stress-rate")
+	}
+}
+
+// stressResult is what a stress client pod observed opening short-lived connections to a target. Latencies are the
+// TCP connect times measured by curl.
+type stressResult struct {
+	Node      string
+	Target    string
+	Kind      string
+	Attempts  int
+	Successes int
+	Latencies []float64
+	Errors    map[string]int
+}
+
+func (r stressResult) successRate() float64 {
+	if r.Attempts == 0 {
+		return 0
+	}
+
+	return float64(r.Successes) / float64(r.Attempts)
+}
+
+// categorizeConnectionError maps the error message of curl to an error category.
+func categorizeConnectionError(message string) string {
+	switch {
+	case strings.Contains(message, "Cannot assign requested address"), strings.Contains(message, "Address not available"):
+		// no free source port for the destination, e.g. exhausted SNAT ports or a full conntrack table
+		return ErrorAddressNotAvailable
+	case strings.Contains(message, "timed out"):
+		return ErrorTimeout
+	case strings.Contains(message, "refused"):
+		return ErrorRefused
+	case strings.Contains(message, "reset"):
+		return ErrorReset
+	case strings.Contains(message, "unreachable"), strings.Contains(message, "No route"):
+		return ErrorUnreachable
+	}
+
+	return ErrorOther
+}
+
+// makeStressClientDaemonsetSpec returns curl pods on every node which open the connections of the stress test.
+func makeStressClientDaemonsetSpec(dmsNamePrefix string, namespace string) *appsv1.DaemonSet {
+	dms := makeDaemonsetSpec(dmsNamePrefix, namespace)
+	dms.Spec.Selector.MatchLabels["sntt"] = "stress-client"
+	dms.Spec.Template.Labels["sntt"] = "stress-client"
+	dms.Spec.Template.Spec.Containers = []corev1.Container{
+		{
+			Image:           CurlImage,
+			Name:            "curl",
+			Command:         []string{"sh", "-c", "sleep 3600"},
+			ImagePullPolicy: corev1.PullIfNotPresent,
+		},
+	}
+
+	return dms
+}
+
+// makeStressScript returns a script which starts rate HTTP requests to url every second for duration, each on a new
+// connection in a process of its own, and prints "ok <connect seconds>" or "err <message>" per request.
+func makeStressScript(url string, rate int, duration time.Duration) string {
+	return fmt.Sprintf("end=$(( $(date +%%s) + %d )); "+
+		"while [ $(date +%%s) -lt $end ]; do "+
+		"i=0; while [ $i -lt %d ]; do "+
+		"( out=$(curl -s -S -o /dev/null -m %d -w '%%{time_connect}' %s 2>&1); "+
+		"if [ $? -eq 0 ]; then echo \"ok $out\"; else echo err $out; fi ) & "+
+		"i=$((i+1)); done; sleep 1; done; wait",
+		int(duration.Seconds()), rate, StressRequestTimeout, url)
+}
+
+// parseStressOutput counts the requests printed by the stress script, and collects connect times of successful ones
+// in milliseconds and failures by error category.
+func parseStressOutput(output string) stressResult {
+	result := stressResult{Errors: map[string]int{}}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (fields[0] != "ok" && fields[0] != "err") {
+			continue
+		}
+		result.Attempts++
+		if fields[0] == "ok" {
+			result.Successes++
+			if seconds, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				result.Latencies = append(result.Latencies, seconds*1000)
+			}
+			continue
+		}
+		result.Errors[categorizeConnectionError(strings.Join(fields[1:], " "))]++
+	}
+
+	return result
+}
+
+func stressTarget(pod corev1.Pod, target string, kind string, url string) stressResult {
+	glog.Infof("opening %d connections per second from pod %s in node %s to %s for %s\n", *stressRate, pod.Name,
+		pod.Spec.NodeName, url, *stressDuration)
+	stdout, stderr, err := execCommandInPod(pod.Name, pod.Namespace, []string{"sh", "-c", makeStressScript(url, *stressRate, *stressDuration)},
+		clientset, config)
+	if err != nil {
+		glog.Errorf("stress script in pod %s failed: %v %s\n", pod.Name, err, stderr)
+	}
+	result := parseStressOutput(stdout)
+	result.Node = pod.Spec.NodeName
+	result.Target = target
+	result.Kind = kind
+
+	return result
+}
+
+// formatCounts formats counts by category as "a=1,b=2" sorted by category.
+func formatCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	var categories []string
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	var items []string
+	for _, category := range categories {
+		items = append(items, fmt.Sprintf("%s=%d", category, counts[category]))
+	}
+
+	return strings.Join(items, ",")
+}
+
+// formatStressResults writes a row per node and target, and a total row per kind of target.
+func formatStressResults(results []stressResult, duration time.Duration) string {
+	totals := map[string]*stressResult{}
+	var kinds []string
+	for _, result := range results {
+		total, ok := totals[result.Kind]
+		if !ok {
+			total = &stressResult{Node: "total", Target: result.Kind, Errors: map[string]int{}}
+			totals[result.Kind] = total
+			kinds = append(kinds, result.Kind)
+		}
+		total.Attempts += result.Attempts
+		total.Successes += result.Successes
+		total.Latencies = append(total.Latencies, result.Latencies...)
+		for category, count := range result.Errors {
+			total.Errors[category] += count
+		}
+	}
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tTARGET\tCONNECTIONS\tPER SECOND\tSUCCESS\tCONNECT P50(ms)\tCONNECT P90(ms)\tCONNECT P99(ms)\tERRORS")
+	write := func(result stressResult) {
+		latencies := "-\t-\t-"
+		if len(result.Latencies) > 0 {
+			latencies = fmt.Sprintf("%.3f\t%.3f\t%.3f", percentile(result.Latencies, 50), percentile(result.Latencies, 90),
+				percentile(result.Latencies, 99))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f\t%.2f%%\t%s\t%s\n", result.Node, result.Target, result.Attempts,
+			float64(result.Attempts)/duration.Seconds(), result.successRate()*100, latencies, formatCounts(result.Errors))
+	}
+	for _, result := range results {
+		write(result)
+	}
+	for _, kind := range kinds {
+		write(*totals[kind])
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// checkConnectionRate opens short-lived connections at -stress-rate per second from the stress client pod of every
+// node to a ClusterIP service and to the probe pod of the next node at the same time, and checks the success rate of
+// both.
+func checkConnectionRate() {
+	echoLabels := map[string]string{"sntt": "echo"}
+	deploy, err := createEchoServerDeployment(clientset, "echo-", testingNamespace.Name, echoLabels, int32(*serviceReplicas))
+	Expect(err).ToNot(HaveOccurred())
+	svc, err := createService(clientset, "echo-", testingNamespace.Name, echoLabels, EchoServerPort, corev1.ServiceTypeClusterIP)
+	Expect(err).ToNot(HaveOccurred())
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	clientDms, err := createDaemonsetObject(clientset, makeStressClientDaemonsetSpec("stress-client-", testingNamespace.Name))
+	Expect(infrastructureError(err)).ToNot(HaveOccurred())
+
+	err = waitTimeoutForDeploymentReady(clientset, deploy.Name, deploy.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForServiceEndpoints(clientset, svc.Name, svc.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, clientDms.Name, clientDms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	pods := podList.Items
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Spec.NodeName < pods[j].Spec.NodeName })
+	clientPodList, err := getPodsWithLabel(clientset, "sntt=stress-client", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	clients := map[string]corev1.Pod{}
+	for _, client := range clientPodList.Items {
+		clients[client.Spec.NodeName] = client
+	}
+	for _, pod := range pods {
+		Expect(clients).To(HaveKey(pod.Spec.NodeName), "no stress client pod is running in node %s", pod.Spec.NodeName)
+	}
+
+	svcURL := fmt.Sprintf("http://%s:%d/hostname", svc.Spec.ClusterIP, EchoServerPort)
+	results := make([]stressResult, 2*len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		// the pod on the next node, or the pod itself with a single node
+		peer := pods[(i+1)%len(pods)]
+		podURL := fmt.Sprintf("http://%s:%d/", peer.Status.PodIP, ProbePodPort)
+
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			results[2*i] = stressTarget(clients[pods[i].Spec.NodeName], svc.Spec.ClusterIP, ProbeKindService, svcURL)
+		}(i)
+		go func(i int, peer corev1.Pod) {
+			defer wg.Done()
+			results[2*i+1] = stressTarget(clients[pods[i].Spec.NodeName], peer.Status.PodIP+"@"+peer.Spec.NodeName, ProbeKindPod, podURL)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	glog.Infof("========== %d connections per second for %s ==========\n%s", *stressRate, *stressDuration,
+		formatStressResults(results, *stressDuration))
+
+	var failed []string
+	for i, result := range results {
+		pod := clients[pods[i/2].Spec.NodeName]
+		probeResult := makeNamedProbeResult(StressCase, &pod, svc.Spec.ClusterIP, EchoServiceName, result.Kind)
+		if result.Kind == ProbeKindPod {
+			peer := pods[(i/2+1)%len(pods)]
+			probeResult = makeProbeResult(StressCase, &pod, peer.Status.PodIP, peer.Spec.NodeName, result.Kind)
+		}
+		probeResult.Expectation = fmt.Sprintf("success rate >= %.3f", *stressMinSuccessRate)
+		probeResult.Attempts = result.Attempts
+		probeResult.Successes = result.Successes
+		probeResult.Classification = classify(result.Attempts, result.Successes)
+		probeResult.Success = result.Attempts > 0 && result.successRate() >= *stressMinSuccessRate
+		if len(result.Latencies) > 0 {
+			probeResult.LatencyMillis = percentile(result.Latencies, 50)
+		}
+		if !probeResult.Success {
+			probeResult.Message = fmt.Sprintf("%d/%d connections succeeded, errors %s", result.Successes, result.Attempts,
+				formatCounts(result.Errors))
+			failed = append(failed, fmt.Sprintf("%s => %s (%s)", result.Node, result.Target, probeResult.Message))
+		}
+		recordProbeResult(probeResult)
+	}
+	Expect(failed).To(BeEmpty(), "success rate of short-lived connections is below -stress-min-success-rate")
+}
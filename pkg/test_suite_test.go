@@ -1,6 +1,8 @@
 package sntt
 
 import (
+	"flag"
+	"fmt"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -8,6 +10,37 @@ import (
 )
 
 func TestTest(t *testing.T) {
+	flag.Set("logtostderr", "true")
+	if *testPlanFile != "" {
+		plan, err := readTestPlan(*testPlanFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPlan = plan
+	}
+	if *printRBAC {
+		if err := validateCaseFilters(); err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := makeRBACManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Print(manifest)
+		return
+	}
+	if *compareMode {
+		regression, report, err := runCompare()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Print(report)
+		if regression {
+			t.Fatal("regression against the baseline")
+		}
+		return
+	}
+
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Test Suite")
 }
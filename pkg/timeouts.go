@@ -0,0 +1,210 @@
+package sntt
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/ginkgo"
+)
+
+const (
+	PhaseProvisioning    = "provisioning"
+	PhaseProbing         = "probing"
+	PhaseTeardown        = "teardown"
+	PhasePollingInterval = "polling-interval"
+	PhaseProbeInterval   = "probe-interval"
+)
+
+// Timeouts are the waits of a test case by phase.
+type Timeouts struct {
+	// Provisioning bounds waits for pods, DaemonSets, Deployments, endpoints and load balancers to become ready.
+	Provisioning time.Duration
+	// Probing bounds how long probes are retried until a pair becomes reachable.
+	Probing time.Duration
+	// Teardown bounds waits for namespaces, pods and DaemonSets to be deleted.
+	Teardown time.Duration
+	// PollingInterval is the pause between retries of a probing or teardown condition.
+	PollingInterval time.Duration
+	// ProbeInterval is the pause between repeated probes of a pair and between provisioning polls.
+	ProbeInterval time.Duration
+}
+
+// phaseFlags are the flags giving the global value of each phase.
+var phaseFlags = map[string]string{
+	PhaseProvisioning:    "provisioning-timeout",
+	PhaseProbing:         "probing-timeout",
+	PhaseTeardown:        "teardown-timeout",
+	PhasePollingInterval: "polling-interval",
+	PhaseProbeInterval:   "probe-interval",
+}
+
+// defaultCaseTimeouts are the built-in overrides of cases waiting for cloud load balancers or gateway addresses.
+var defaultCaseTimeouts = map[string]map[string]string{
+	"F-3": {PhaseProvisioning: "5m"},
+	"F-4": {PhaseProvisioning: "5m"},
+	"J":   {PhaseProvisioning: "5m"},
+}
+
+var (
+	// timeouts of the running case, set before each case
+	timeouts = Timeouts{
+		Provisioning:    ProvisioningTimeout,
+		Probing:         Timeout,
+		Teardown:        Timeout,
+		PollingInterval: PollingInterval,
+		ProbeInterval:   pollIntervalToPing,
+	}
+
+	infrastructureErrorMutex sync.Mutex
+	firstInfrastructureError error
+)
+
+var caseIDPattern = regexp.MustCompile(`^\[([A-Z](-[0-9]+)?)\]`)
+
+// getCurrentCaseID returns the ID in square brackets at the start of the Describe text of the running case.
+func getCurrentCaseID() string {
+	for _, text := range CurrentGinkgoTestDescription().ComponentTexts {
+		if match := caseIDPattern.FindStringSubmatch(text); match != nil {
+			return match[1]
+		}
+	}
+
+	return ""
+}
+
+func setTimeout(t *Timeouts, phase string, value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s '%s': %v", phase, value, err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("%s must be positive", phase)
+	}
+
+	switch phase {
+	case PhaseProvisioning:
+		t.Provisioning = duration
+	case PhaseProbing:
+		t.Probing = duration
+	case PhaseTeardown:
+		t.Teardown = duration
+	case PhasePollingInterval:
+		t.PollingInterval = duration
+	case PhaseProbeInterval:
+		t.ProbeInterval = duration
+	default:
+		return fmt.Errorf("unknown phase '%s', one of 'provisioning', 'probing', 'teardown', 'polling-interval', 'probe-interval'", phase)
+	}
+
+	return nil
+}
+
+// parseCaseTimeoutFlags parses -case-timeout values of the form '<case>.<phase>=<duration>' by case ID.
+func parseCaseTimeoutFlags(values []string) (map[string]map[string]string, error) {
+	caseTimeouts := map[string]map[string]string{}
+	for _, value := range values {
+		keyValue := strings.SplitN(value, "=", 2)
+		caseAndPhase := strings.SplitN(keyValue[0], ".", 2)
+		if len(keyValue) != 2 || len(caseAndPhase) != 2 {
+			return nil, fmt.Errorf("invalid -case-timeout '%s', expected '<case>.<phase>=<duration>'", value)
+		}
+		if caseTimeouts[caseAndPhase[0]] == nil {
+			caseTimeouts[caseAndPhase[0]] = map[string]string{}
+		}
+		caseTimeouts[caseAndPhase[0]][caseAndPhase[1]] = keyValue[1]
+	}
+
+	return caseTimeouts, nil
+}
+
+// getCaseTimeouts returns the timeouts of the case. Later ones win: flag defaults, built-in case overrides, the
+// test plan, flags given on the command line, cases of the test plan and -case-timeout.
+func getCaseTimeouts(caseID string) (Timeouts, error) {
+	layers := []map[string]string{{}, defaultCaseTimeouts[caseID], testPlan.Timeouts, {}}
+	for phase, name := range phaseFlags {
+		layers[0][phase] = flag.Lookup(name).DefValue
+	}
+	flag.Visit(func(f *flag.Flag) {
+		for phase, name := range phaseFlags {
+			if f.Name == name {
+				layers[3][phase] = f.Value.String()
+			}
+		}
+	})
+	caseTimeouts, err := parseCaseTimeoutFlags(caseTimeoutFlags)
+	if err != nil {
+		return Timeouts{}, err
+	}
+	layers = append(layers, testPlan.Cases[caseID].Timeouts, caseTimeouts[caseID])
+
+	var t Timeouts
+	for _, layer := range layers {
+		for phase, value := range layer {
+			if err := setTimeout(&t, phase, value); err != nil {
+				return Timeouts{}, err
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// validateTimeoutFlags checks the timeouts of every case the flags or the test plan mention.
+func validateTimeoutFlags() error {
+	caseTimeouts, err := parseCaseTimeoutFlags(caseTimeoutFlags)
+	if err != nil {
+		return err
+	}
+	caseIDs := []string{""}
+	for caseID := range caseTimeouts {
+		caseIDs = append(caseIDs, caseID)
+	}
+	for caseID := range testPlan.Cases {
+		caseIDs = append(caseIDs, caseID)
+	}
+	for _, caseID := range caseIDs {
+		if _, err := getCaseTimeouts(caseID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isFailFast() bool {
+	return *failFast || testPlan.FailFast
+}
+
+// infrastructureError remembers the first error of provisioning or teardown, after which the remaining cases are
+// skipped in fail-fast mode, and returns err as it is.
+func infrastructureError(err error) error {
+	if err == nil {
+		return nil
+	}
+	infrastructureErrorMutex.Lock()
+	defer infrastructureErrorMutex.Unlock()
+
+	if firstInfrastructureError == nil {
+		firstInfrastructureError = err
+		if isFailFast() {
+			glog.Errorf("infrastructure error, the remaining cases are skipped: %v\n", err)
+		}
+	}
+
+	return err
+}
+
+// skipAfterInfrastructureError skips the case when an earlier one failed on infrastructure in fail-fast mode.
+func skipAfterInfrastructureError() {
+	infrastructureErrorMutex.Lock()
+	defer infrastructureErrorMutex.Unlock()
+
+	if isFailFast() && firstInfrastructureError != nil {
+		Skip(fmt.Sprintf("skipped by fail-fast after infrastructure error: %v", firstInfrastructureError))
+	}
+}
@@ -0,0 +1,263 @@
+package sntt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/golang/glog"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+)
+
+const (
+	TopologyCase = "L"
+
+	// NoTopology is the group of nodes without the topology label.
+	NoTopology = "<none>"
+)
+
+// getPingRTTsFromPodToIP pings destination from the pod and returns the round-trip time of every reply in
+// milliseconds.
+func getPingRTTsFromPodToIP(podName string, namespace string, destinationIPAddress string, count int,
+	clientset *kubernetes.Clientset, config *restclient.Config) ([]float64, error) {
+	command := []string{"/bin/ping", "-c", strconv.Itoa(count), "-W", "2", destinationIPAddress}
+	stdout, stderr, err := execCommandInPod(podName, namespace, command, clientset, config)
+	if err != nil {
+		return nil, fmt.Errorf("ping %s from pod %s failed: %v %s", destinationIPAddress, podName, err, stderr)
+	}
+
+	return parsePingRTTs(stdout)
+}
+
+// parsePingRTTs returns the round-trip times of "64 bytes from 10.0.0.1: seq=0 ttl=64 time=0.095 ms" lines in ping
+// output in milliseconds.
+func parsePingRTTs(output string) ([]float64, error) {
+	var rtts []float64
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "seq=") {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if !strings.HasPrefix(field, "time=") {
+				continue
+			}
+			rtt, err := strconv.ParseFloat(strings.TrimPrefix(field, "time="), 64)
+			if err != nil {
+				return nil, err
+			}
+			rtts = append(rtts, rtt)
+		}
+	}
+	if len(rtts) == 0 {
+		return nil, fmt.Errorf("no round-trip time in ping output %q", output)
+	}
+
+	return rtts, nil
+}
+
+func getNodeTopology(node *corev1.Node, key string) string {
+	if value, ok := node.Labels[key]; ok && value != "" {
+		return value
+	}
+
+	return NoTopology
+}
+
+// groupNodesByTopology groups node names by the value of the topology label key.
+func groupNodesByTopology(nodeList []corev1.Node, key string) map[string][]string {
+	groups := map[string][]string{}
+	for i := range nodeList {
+		topology := getNodeTopology(&nodeList[i], key)
+		groups[topology] = append(groups[topology], nodeList[i].Name)
+	}
+	for topology := range groups {
+		sort.Strings(groups[topology])
+	}
+
+	return groups
+}
+
+// selectNodesByTopology picks at most maxPerGroup nodes of every topology group, all of them when maxPerGroup <= 0.
+func selectNodesByTopology(groups map[string][]string, maxPerGroup int) map[string]bool {
+	selected := map[string]bool{}
+	for _, nodeNames := range groups {
+		for i, nodeName := range nodeNames {
+			if maxPerGroup > 0 && i >= maxPerGroup {
+				break
+			}
+			selected[nodeName] = true
+		}
+	}
+
+	return selected
+}
+
+// percentile returns the p-th percentile (0-100) of values with the nearest-rank method.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// topologyProbe is a ping from a pod to another with the round-trip time of every reply.
+type topologyProbe struct {
+	result ProbeResult
+	sent   int
+	rtts   []float64
+}
+
+// formatTopologySummary aggregates probes by zone pair and into intra-zone and inter-zone totals. Percentiles are
+// taken over the round-trip times of every reply, not over averages per pair of pods.
+func formatTopologySummary(probes []topologyProbe) string {
+	type aggregate struct {
+		total, success int
+		sent           int
+		rtts           []float64
+	}
+	pairs := map[string]*aggregate{}
+	scopes := map[string]*aggregate{}
+	add := func(aggregates map[string]*aggregate, key string, probe topologyProbe) {
+		if _, ok := aggregates[key]; !ok {
+			aggregates[key] = &aggregate{}
+		}
+		aggregates[key].total++
+		aggregates[key].sent += probe.sent
+		aggregates[key].rtts = append(aggregates[key].rtts, probe.rtts...)
+		if probe.result.Success {
+			aggregates[key].success++
+		}
+	}
+	for _, probe := range probes {
+		add(pairs, probe.result.SourceZone+" => "+probe.result.TargetZone, probe)
+		if probe.result.SourceZone == probe.result.TargetZone {
+			add(scopes, "intra-zone", probe)
+		} else {
+			add(scopes, "inter-zone", probe)
+		}
+	}
+
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ZONE PAIR\tSUCCESS\tREPLIES\tP50(ms)\tP90(ms)\tP99(ms)")
+	write := func(aggregates map[string]*aggregate) {
+		var keys []string
+		for key := range aggregates {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			a := aggregates[key]
+			fmt.Fprintf(writer, "%s\t%d/%d\t%d/%d\t%.3f\t%.3f\t%.3f\n", key, a.success, a.total, len(a.rtts), a.sent,
+				percentile(a.rtts, 50), percentile(a.rtts, 90), percentile(a.rtts, 99))
+		}
+	}
+	write(scopes)
+	write(pairs)
+	writer.Flush()
+
+	return builder.String()
+}
+
+// checkTopologyMatrix pings between the probe pods of the selected nodes of every topology group and reports
+// success rate and latency percentiles aggregated intra-zone, inter-zone and per zone pair.
+func checkTopologyMatrix() {
+	groups := groupNodesByTopology(nodes.Items, *topologyKey)
+	selectedNodes := selectNodesByTopology(groups, *topologyNodesPerGroup)
+	for topology, nodeNames := range groups {
+		glog.Infof("%s=%s : %v\n", *topologyKey, topology, nodeNames)
+	}
+
+	dms, err := createDaemonset(clientset, PodName1Prefix, testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+	err = waitTimeoutForDaemonsetReady(clientset, dms.Name, dms.Namespace, timeouts.Provisioning)
+	Expect(err).ToNot(HaveOccurred())
+	podList, err := getPodsWithLabel(clientset, "sntt=daemonset", testingNamespace.Name)
+	Expect(err).ToNot(HaveOccurred())
+
+	nodeTopology := map[string]string{}
+	for i := range nodes.Items {
+		nodeTopology[nodes.Items[i].Name] = getNodeTopology(&nodes.Items[i], *topologyKey)
+	}
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if selectedNodes[pod.Spec.NodeName] {
+			pods = append(pods, pod)
+		}
+	}
+
+	var probes []topologyProbe
+	var probesMutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, source := range pods {
+		wg.Add(1)
+		go func(source corev1.Pod) {
+			defer wg.Done()
+			for _, target := range pods {
+				if source.Name == target.Name {
+					continue
+				}
+				result := ProbeResult{
+					Case:       TopologyCase,
+					SourceNode: source.Spec.NodeName,
+					SourcePod:  source.Name,
+					SourceZone: nodeTopology[source.Spec.NodeName],
+					TargetNode: target.Spec.NodeName,
+					TargetZone: nodeTopology[target.Spec.NodeName],
+					Target:     target.Status.PodIP,
+					Kind:       ProbeKindPod,
+				}
+				// the round-trip times of the last successful attempt are kept
+				probe := topologyProbe{}
+				expectation := getReachableExpectation()
+				outcome := evaluateExpectation(expectation, func() bool {
+					if !isICMPProbeAllowed() {
+						return isPossibleToPingFromPodToIP(source.Name, source.Namespace, target.Status.PodIP, clientset, config)
+					}
+					rtts, err := getPingRTTsFromPodToIP(source.Name, source.Namespace, target.Status.PodIP, *topologyPingCount, clientset, config)
+					if err != nil {
+						glog.Info(err)
+						return false
+					}
+					probe.sent = *topologyPingCount
+					probe.rtts = rtts
+					return true
+				})
+				result = applyProbeOutcome(result, expectation, outcome)
+				if len(probe.rtts) > 0 {
+					result.LatencyMillis = percentile(probe.rtts, 50)
+				}
+				recordProbeResult(result)
+				probe.result = result
+				probesMutex.Lock()
+				probes = append(probes, probe)
+				probesMutex.Unlock()
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	glog.Infof("========== pod-to-pod connectivity by %s ==========\n%s", *topologyKey, formatTopologySummary(probes))
+
+	var unreachable []string
+	for _, probe := range probes {
+		if result := probe.result; !result.Success {
+			unreachable = append(unreachable, fmt.Sprintf("%s(%s) => %s(%s)", result.SourceNode, result.SourceZone, result.TargetNode, result.TargetZone))
+		}
+	}
+	Expect(unreachable).To(BeEmpty())
+}
@@ -2,25 +2,28 @@ package sntt
 
 import (
 	"bytes"
-	"flag"
 	"fmt"
 	"github.com/golang/glog"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	wait "k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
-const pollIntervalToPing = 2 * time.Second // retry every 3 s
+const pollIntervalToPing = 2 * time.Second // retry every 2 s by default, see -probe-interval
 
 // getKubeconfigPathFromEnv gets the path to the first kubeconfig
 func getKubeconfigPathFromEnv() string {
@@ -29,7 +32,8 @@ func getKubeconfigPathFromEnv() string {
 	if kubeConfigEnv == "" {
 		home, err := os.UserHomeDir()
 		if err != nil {
-			panic(err)
+			// running in a pod without HOME, in-cluster config is used instead
+			return ""
 		}
 		kubeConfigEnv = filepath.Join(home, ".kube", "config")
 	}
@@ -37,26 +41,55 @@ func getKubeconfigPathFromEnv() string {
 	return kubeConfigEnv
 }
 
-func getClientSet() (*kubernetes.Clientset, *restclient.Config) {
-	var kubeconfig *string
-	flag.Set("logtostderr", "true")
-	glog.Info("========== [TEST] Start Fetching Current kubernetes client ==========\n")
+// getRestConfig builds the client config from the kubeconfig with the given context, or from the service account
+// of the pod when sntt runs in a cluster without a kubeconfig. Impersonation and rate limits are taken from flags.
+func getRestConfig(context string) (*restclient.Config, error) {
+	var config *restclient.Config
+	var err error
+
+	if _, statErr := os.Stat(*kubeconfig); statErr == nil {
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: *kubeconfig}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	} else {
+		if context != "" {
+			return nil, fmt.Errorf("context %s is given but kubeconfig %s does not exist", context, *kubeconfig)
+		}
+		glog.Infof("kubeconfig %s does not exist, using in-cluster config", *kubeconfig)
+		config, err = restclient.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.Impersonate = restclient.ImpersonationConfig{
+		UserName: *impersonateUser,
+		Groups:   impersonateGroups,
+	}
+	config.QPS = float32(*kubeAPIQPS)
+	config.Burst = *kubeAPIBurst
 
-	kubeconfig = flag.String("kubeconfig", getKubeconfigPathFromEnv(), "absolute path to the kubeconfig file")
+	return config, nil
+}
 
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+func getClientSetForContext(context string) (*kubernetes.Clientset, *restclient.Config, error) {
+	config, err := getRestConfig(context)
 	if err != nil {
-		panic(err.Error())
+		return nil, nil, err
 	}
 
-	// create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		return nil, nil, err
 	}
 
-	return clientset, config
+	return clientset, config, nil
+}
+
+func getClientSet() (*kubernetes.Clientset, *restclient.Config, error) {
+	glog.Info("========== [TEST] Start Fetching Current kubernetes client ==========\n")
+
+	return getClientSetForContext(*kubeContext)
 }
 
 func makeNamespaceSpec(namespacePrefix string) *corev1.Namespace {
@@ -67,6 +100,7 @@ func makeNamespaceSpec(namespacePrefix string) *corev1.Namespace {
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: namespacePrefix,
+			Labels:       getPodSecurityLabels(),
 		},
 	}
 
@@ -76,12 +110,37 @@ func makeNamespaceSpec(namespacePrefix string) *corev1.Namespace {
 func createNamespace(clientset *kubernetes.Clientset, nsSpec *corev1.Namespace) (*corev1.Namespace, error) {
 	ns, err := clientset.CoreV1().Namespaces().Create(nsSpec)
 
-	return ns, err
+	return ns, infrastructureError(err)
+}
+
+// waitTimeoutForNamespaceDeleted waits until the namespace and everything in it is deleted.
+func waitTimeoutForNamespaceDeleted(clientset *kubernetes.Clientset, namespace string, timeout time.Duration) error {
+	err := wait.PollImmediate(timeouts.PollingInterval, timeout, func() (bool, error) {
+		ns, err := clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			return errors.IsNotFound(err), nil
+		}
+		glog.Infof("Namespace %s is still in phase %s\n", namespace, ns.Status.Phase)
+		return false, nil
+	})
+
+	if err != nil {
+		return infrastructureError(fmt.Errorf("Namespace %s is not deleted within %v", namespace, timeout))
+	}
+
+	return nil
+}
+
+// getProbePodCommand keeps probe pods running and answers with the hostname on ProbePodPort, so that TCP probes
+// can be used where ping is not allowed.
+func getProbePodCommand() []string {
+	script := fmt.Sprintf("mkdir -p /tmp/www && hostname > /tmp/www/index.html && httpd -p %d -h /tmp/www; sleep 3600", ProbePodPort)
+
+	return []string{"sh", "-c", script}
 }
 
 func makePodSpecInSpecificNode(podNamePrefix string, nodeName string, namespace string) *corev1.Pod {
-	//TODO need to be clean
-	cmd := []string{"sleep", "3600"}
+	cmd := getProbePodCommand()
 
 	podSpec := &corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
@@ -110,8 +169,7 @@ func makePodSpecInSpecificNode(podNamePrefix string, nodeName string, namespace
 }
 
 func makePodSpec(podNamePrefix string, namespace string) *corev1.Pod {
-	//TODO need to be clean
-	cmd := []string{"sleep", "3600"}
+	cmd := getProbePodCommand()
 
 	podSpec := &corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
@@ -139,7 +197,7 @@ func makePodSpec(podNamePrefix string, namespace string) *corev1.Pod {
 }
 
 func makeDaemonsetSpec(dmsNamePrefix string, namespace string) *appsv1.DaemonSet {
-	cmd := []string{"sleep", "3600"}
+	cmd := getProbePodCommand()
 
 	dmsSpec := &appsv1.DaemonSet{
 		TypeMeta: metav1.TypeMeta{
@@ -180,32 +238,174 @@ func makeDaemonsetSpec(dmsNamePrefix string, namespace string) *appsv1.DaemonSet
 	return dmsSpec
 }
 
+// makeHostNetworkDaemonsetSpec returns a DaemonSet whose pods share the network namespace of their node,
+// so that they can be used to test node-to-pod paths.
+func makeHostNetworkDaemonsetSpec(dmsNamePrefix string, namespace string) *appsv1.DaemonSet {
+	dmsSpec := makeDaemonsetSpec(dmsNamePrefix, namespace)
+	dmsSpec.Spec.Selector.MatchLabels["sntt"] = "hostnetwork-daemonset"
+	dmsSpec.Spec.Template.Labels["sntt"] = "hostnetwork-daemonset"
+	dmsSpec.Spec.Template.Spec.HostNetwork = true
+	dmsSpec.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+
+	return dmsSpec
+}
+
+func makeEchoServerContainer() corev1.Container {
+	args := []string{"netexec", fmt.Sprintf("--http-port=%d", EchoServerPort)}
+
+	return corev1.Container{
+		Image:           EchoServerImage,
+		Name:            "echo",
+		Args:            args,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "http",
+				ContainerPort: EchoServerPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+	}
+}
+
+func makeEchoServerPodSpecInSpecificNode(podNamePrefix string, nodeName string, namespace string,
+	labels map[string]string) *corev1.Pod {
+	podSpec := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "appsv1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: podNamePrefix,
+			Namespace:    namespace,
+			Labels:       labels,
+		},
+		Spec: corev1.PodSpec{
+			Containers:    []corev1.Container{makeEchoServerContainer()},
+			RestartPolicy: corev1.RestartPolicyAlways,
+			NodeName:      nodeName,
+		},
+	}
+
+	return podSpec
+}
+
+func makeEchoServerDeploymentSpec(deployNamePrefix string, namespace string, labels map[string]string,
+	replicas int32) *appsv1.Deployment {
+	deploySpec := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: deployNamePrefix,
+			Namespace:    namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers:    []corev1.Container{makeEchoServerContainer()},
+					RestartPolicy: corev1.RestartPolicyAlways,
+				},
+			},
+		},
+	}
+
+	return deploySpec
+}
+
+func makeServiceSpec(svcNamePrefix string, namespace string, selector map[string]string, port int32,
+	svcType corev1.ServiceType) *corev1.Service {
+	svcSpec := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: svcNamePrefix,
+			Namespace:    namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     svcType,
+			Selector: selector,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Port:       port,
+					TargetPort: intstr.FromInt(int(port)),
+					Protocol:   corev1.ProtocolTCP,
+				},
+			},
+		},
+	}
+
+	return svcSpec
+}
+
 func createPodInSpecificNode(clientset *kubernetes.Clientset, podName string, nodeName string, namespace string) (*corev1.Pod, error) {
 	pod := makePodSpecInSpecificNode(podName, nodeName, namespace)
-	podOut, err := clientset.CoreV1().Pods(namespace).Create(pod)
+	podOut, err := createPod(clientset, pod)
 
-	return podOut, err
+	return podOut, infrastructureError(err)
 }
 
 func createPodInRandomNode(clientset *kubernetes.Clientset, podName string, namespace string) (*corev1.Pod, error) {
 	pod := makePodSpec(podName, namespace)
-	podOut, err := clientset.CoreV1().Pods(namespace).Create(pod)
+	podOut, err := createPod(clientset, pod)
 
-	return podOut, err
+	return podOut, infrastructureError(err)
 }
 
 func createDaemonset(clientset *kubernetes.Clientset, dmsName string, namespace string) (*appsv1.DaemonSet, error) {
 	dms := makeDaemonsetSpec(dmsName, namespace)
-	dmsOut, err := clientset.AppsV1().DaemonSets(namespace).Create(dms)
+	dmsOut, err := createDaemonsetObject(clientset, dms)
+
+	return dmsOut, infrastructureError(err)
+}
+
+func createHostNetworkDaemonset(clientset *kubernetes.Clientset, dmsName string, namespace string) (*appsv1.DaemonSet, error) {
+	dms := makeHostNetworkDaemonsetSpec(dmsName, namespace)
+	dmsOut, err := createDaemonsetObject(clientset, dms)
+
+	return dmsOut, infrastructureError(err)
+}
+
+func createEchoServerPodInSpecificNode(clientset *kubernetes.Clientset, podName string, nodeName string, namespace string,
+	labels map[string]string) (*corev1.Pod, error) {
+	pod := makeEchoServerPodSpecInSpecificNode(podName, nodeName, namespace, labels)
+	podOut, err := createPod(clientset, pod)
+
+	return podOut, infrastructureError(err)
+}
+
+func createEchoServerDeployment(clientset *kubernetes.Clientset, deployName string, namespace string,
+	labels map[string]string, replicas int32) (*appsv1.Deployment, error) {
+	deploy := makeEchoServerDeploymentSpec(deployName, namespace, labels, replicas)
+	deployOut, err := createDeploymentObject(clientset, deploy)
 
-	return dmsOut, err
+	return deployOut, infrastructureError(err)
+}
+
+func createService(clientset *kubernetes.Clientset, svcName string, namespace string, selector map[string]string,
+	port int32, svcType corev1.ServiceType) (*corev1.Service, error) {
+	svc := makeServiceSpec(svcName, namespace, selector, port, svcType)
+	svcOut, err := clientset.CoreV1().Services(namespace).Create(svc)
+
+	return svcOut, infrastructureError(err)
 }
 
 func waitTimeoutForPodStatus(clientset *kubernetes.Clientset, podName string, namespace string,
 	desiredStatus corev1.PodPhase, timeout time.Duration) error {
 	var pod *corev1.Pod
 
-	err := wait.PollImmediate(pollIntervalToPing, timeout, func() (bool, error) {
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
 		pod, err := clientset.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
 		if err != nil || pod.Status.Phase != desiredStatus {
 			return false, err
@@ -214,7 +414,21 @@ func waitTimeoutForPodStatus(clientset *kubernetes.Clientset, podName string, na
 	})
 
 	if err != nil {
-		return fmt.Errorf("Pod %s not in phase %s within %v ", pod, desiredStatus, timeout)
+		return infrastructureError(fmt.Errorf("Pod %s not in phase %s within %v ", pod, desiredStatus, timeout))
+	}
+
+	return nil
+}
+
+// waitTimeoutForPodDeleted waits until the pod is gone, so that its IP can be given to another pod.
+func waitTimeoutForPodDeleted(clientset *kubernetes.Clientset, podName string, namespace string, timeout time.Duration) error {
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		_, err := clientset.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+		return errors.IsNotFound(err), nil
+	})
+
+	if err != nil {
+		return infrastructureError(fmt.Errorf("Pod %s is not deleted within %v", podName, timeout))
 	}
 
 	return nil
@@ -223,7 +437,7 @@ func waitTimeoutForPodStatus(clientset *kubernetes.Clientset, podName string, na
 func waitTimeoutForDaemonsetReady(clientset *kubernetes.Clientset, dmsName string, namespace string,
 	timeout time.Duration) error {
 
-	err := wait.PollImmediate(pollIntervalToPing, timeout, func() (bool, error) {
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
 		dmsout, err := clientset.AppsV1().DaemonSets(namespace).Get(dmsName, metav1.GetOptions{})
 		if err != nil || dmsout.Status.DesiredNumberScheduled != dmsout.Status.NumberReady ||
 			dmsout.Status.DesiredNumberScheduled != dmsout.Status.NumberAvailable {
@@ -234,12 +448,179 @@ func waitTimeoutForDaemonsetReady(clientset *kubernetes.Clientset, dmsName strin
 	})
 
 	if err != nil {
-		return fmt.Errorf("Daemonset %s is not ready yet", dmsName)
+		return infrastructureError(fmt.Errorf("Daemonset %s is not ready yet", dmsName))
+	}
+	checkDaemonsetCoverage(clientset, dmsName, namespace)
+
+	return nil
+}
+
+func waitTimeoutForDeploymentReady(clientset *kubernetes.Clientset, deployName string, namespace string,
+	timeout time.Duration) error {
+
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		deployOut, err := clientset.AppsV1().Deployments(namespace).Get(deployName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if deployOut.Spec.Replicas == nil || deployOut.Status.ObservedGeneration < deployOut.Generation ||
+			deployOut.Status.ReadyReplicas != *deployOut.Spec.Replicas ||
+			deployOut.Status.UpdatedReplicas != *deployOut.Spec.Replicas {
+			glog.Infof("Deployment %s is still rolling out", deployName)
+			return false, nil
+		}
+		return true, nil
+	})
+
+	if err != nil {
+		return infrastructureError(fmt.Errorf("Deployment %s is not ready within %v", deployName, timeout))
 	}
 
 	return nil
 }
 
+// waitTimeoutForServiceEndpoints waits until the service has at least one ready endpoint address.
+func waitTimeoutForServiceEndpoints(clientset *kubernetes.Clientset, svcName string, namespace string,
+	timeout time.Duration) error {
+
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		endpoints, err := clientset.CoreV1().Endpoints(namespace).Get(svcName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for _, subset := range endpoints.Subsets {
+			if len(subset.Addresses) > 0 {
+				return true, nil
+			}
+		}
+		glog.Infof("Service %s has no ready endpoints yet", svcName)
+		return false, nil
+	})
+
+	if err != nil {
+		return infrastructureError(fmt.Errorf("Service %s has no ready endpoints within %v", svcName, timeout))
+	}
+
+	return nil
+}
+
+func waitTimeoutForLoadBalancerIngress(clientset *kubernetes.Clientset, svcName string, namespace string,
+	timeout time.Duration) (string, error) {
+	var ingressAddress string
+
+	err := wait.PollImmediate(timeouts.ProbeInterval, timeout, func() (bool, error) {
+		svc, err := clientset.CoreV1().Services(namespace).Get(svcName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				ingressAddress = ingress.IP
+				return true, nil
+			}
+			if ingress.Hostname != "" {
+				ingressAddress = ingress.Hostname
+				return true, nil
+			}
+		}
+		glog.Infof("Service %s has no load balancer ingress yet", svcName)
+		return false, nil
+	})
+
+	if err != nil {
+		return "", infrastructureError(fmt.Errorf("Service %s has no load balancer ingress within %v", svcName, timeout))
+	}
+
+	return ingressAddress, nil
+}
+
+func getPodsWithLabel(clientset *kubernetes.Clientset, labelSelector string, namespace string) (*corev1.PodList, error) {
+	listOptions := metav1.ListOptions{}
+	listOptions.LabelSelector = labelSelector
+
+	return clientset.CoreV1().Pods(namespace).List(listOptions)
+}
+
+func scaleDeployment(clientset *kubernetes.Clientset, deployName string, namespace string, replicas int32) error {
+	deploy, err := clientset.AppsV1().Deployments(namespace).Get(deployName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	deploy.Spec.Replicas = &replicas
+	_, err = clientset.AppsV1().Deployments(namespace).Update(deploy)
+
+	return err
+}
+
+// getServedGroupVersionResource returns the resource in the first of versions the API server serves.
+func getServedGroupVersionResource(group string, versions []string, resource string) (schema.GroupVersionResource, bool) {
+	gvr := getServedGroupVersionResourceFor(clientset, group, versions, resource)
+	if gvr == nil {
+		return schema.GroupVersionResource{}, false
+	}
+
+	return *gvr, true
+}
+
+func getServedGroupVersionResourceFor(clientset *kubernetes.Clientset, group string, versions []string,
+	resource string) *schema.GroupVersionResource {
+	for _, version := range versions {
+		gv := schema.GroupVersion{Group: group, Version: version}
+		resourceList, err := clientset.Discovery().ServerResourcesForGroupVersion(gv.String())
+		if err != nil {
+			continue
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if apiResource.Name == resource {
+				gvr := gv.WithResource(resource)
+				return &gvr
+			}
+		}
+	}
+
+	return nil
+}
+
+func getNodeInternalIP(node *corev1.Node) (string, error) {
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address, nil
+		}
+	}
+
+	return "", fmt.Errorf("Node %s has no InternalIP address", node.Name)
+}
+
+// parseClientAddress extracts the IP address from a whoami response, which is either "ip" or "ip:port".
+func parseClientAddress(response string) (string, error) {
+	address := strings.TrimSpace(response)
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if net.ParseIP(address) == nil {
+		return "", fmt.Errorf("unexpected whoami response %q", response)
+	}
+
+	return address, nil
+}
+
+// getExpectedSourceIP returns the source address the whoami endpoint should observe for traffic from the pod.
+func getExpectedSourceIP(policy string, pod *corev1.Pod, egressIP string) (string, error) {
+	switch policy {
+	case SNATPolicyNode:
+		return pod.Status.HostIP, nil
+	case SNATPolicyEgressIP:
+		if egressIP == "" {
+			return "", fmt.Errorf("-snat-egress-ip is required with -snat-policy=%s", SNATPolicyEgressIP)
+		}
+		return egressIP, nil
+	case SNATPolicyNone:
+		return pod.Status.PodIP, nil
+	}
+
+	return "", fmt.Errorf("unknown SNAT policy %q", policy)
+}
+
 func getPodIP(clientset *kubernetes.Clientset, podName string, namespace string) (string, error) {
 	out, err := clientset.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
 
@@ -251,10 +632,42 @@ func getPodIP(clientset *kubernetes.Clientset, podName string, namespace string)
 // 아래 코드는 a4abhishek / Client-Go-Examples 의 github 참고
 func isPossibleToPingFromPodToIP(podName string, namespace string, destinationIPAddress string, clientset *kubernetes.Clientset,
 	config *restclient.Config) bool {
-	glog.Infof("====== Trying to ping from '%s' pod => '%s' for every %.1f seconds ======", podName, destinationIPAddress, pollIntervalToPing.Seconds())
+	if !isICMPProbeAllowed() {
+		return isPossibleToConnectFromPodToIP(podName, namespace, destinationIPAddress, getTCPFallbackPort(destinationIPAddress), clientset, config)
+	}
+
+	glog.Infof("====== Trying to ping from '%s' pod => '%s' for every %.1f seconds ======", podName, destinationIPAddress, timeouts.ProbeInterval.Seconds())
 	//TODO 커맨드에 ping 명령어 이후 파이프라인(|)이랑 "> /dev/null" 먹지 않아서 조잡하게 코드 짰는데 확인 필요
 	command := []string{"/bin/ping", "-c", "2", destinationIPAddress}
 
+	stdout, _, err := execCommandInPod(podName, namespace, command, clientset, config)
+	if err != nil {
+		return false
+	}
+
+	if !strings.Contains(stdout, "0% packet loss") {
+		return false
+	}
+
+	return true
+}
+
+// isPossibleToConnectFromPodToIP is a TCP probe used instead of ping when probe pods have no NET_RAW capability.
+func isPossibleToConnectFromPodToIP(podName string, namespace string, destinationIPAddress string, port int,
+	clientset *kubernetes.Clientset, config *restclient.Config) bool {
+	recordProbeDowngrade(fmt.Sprintf("ping %s => tcp %s:%d", podName, destinationIPAddress, port))
+	glog.Infof("====== Trying to connect from '%s' pod => '%s:%d' ======", podName, destinationIPAddress, port)
+	command := []string{"sh", "-c", fmt.Sprintf("nc -w 2 %s %d < /dev/null", destinationIPAddress, port)}
+
+	_, _, err := execCommandInPod(podName, namespace, command, clientset, config)
+
+	return err == nil
+}
+
+// execCommandInPod runs command in the first container of the pod and returns its stdout and stderr.
+// A non-zero exit code of the command is returned as an error.
+func execCommandInPod(podName string, namespace string, command []string, clientset *kubernetes.Clientset,
+	config *restclient.Config) (string, string, error) {
 	req := clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(podName).
@@ -263,7 +676,7 @@ func isPossibleToPingFromPodToIP(podName string, namespace string, destinationIP
 
 	scheme := runtime.NewScheme()
 	if err := corev1.AddToScheme(scheme); err != nil {
-		return false
+		return "", "", err
 	}
 
 	parameterCodec := runtime.NewParameterCodec(scheme)
@@ -278,7 +691,7 @@ func isPossibleToPingFromPodToIP(podName string, namespace string, destinationIP
 
 	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
 	if err != nil {
-		return false
+		return "", "", err
 	}
 
 	var stdout, stderr bytes.Buffer
@@ -289,11 +702,53 @@ func isPossibleToPingFromPodToIP(podName string, namespace string, destinationIP
 		Tty:    false,
 	})
 
+	return stdout.String(), stderr.String(), err
+}
+
+// getHTTPResponseFromPod fetches url with busybox wget from inside the pod and returns the response body.
+func getHTTPResponseFromPod(podName string, namespace string, url string, clientset *kubernetes.Clientset,
+	config *restclient.Config) (string, error) {
+	return getHTTPResponseFromPodWithHeaders(podName, namespace, url, nil, clientset, config)
+}
+
+// getHTTPResponseFromPodWithHeaders is getHTTPResponseFromPod with extra request headers such as "Host: example.com".
+// Certificates of https urls are not verified.
+func getHTTPResponseFromPodWithHeaders(podName string, namespace string, url string, headers []string,
+	clientset *kubernetes.Clientset, config *restclient.Config) (string, error) {
+	command := []string{"wget", "-q", "-O", "-", "-T", "5"}
+	for _, header := range headers {
+		command = append(command, "--header", header)
+	}
+	if strings.HasPrefix(url, "https://") {
+		command = append(command, "--no-check-certificate")
+	}
+	command = append(command, url)
+
+	stdout, stderr, err := execCommandInPod(podName, namespace, command, clientset, config)
 	if err != nil {
-		return false
+		return "", fmt.Errorf("wget %s from pod %s failed: %v %s", url, podName, err, stderr)
 	}
 
-	if !strings.Contains(stdout.String(), "0% packet loss") {
+	return stdout, nil
+}
+
+// getObservedClientIPFromPod requests a whoami url from the pod and returns the client address the server observed.
+func getObservedClientIPFromPod(podName string, namespace string, url string, clientset *kubernetes.Clientset,
+	config *restclient.Config) (string, error) {
+	response, err := getHTTPResponseFromPod(podName, namespace, url, clientset, config)
+	if err != nil {
+		return "", err
+	}
+
+	return parseClientAddress(response)
+}
+
+func isPossibleToRequestFromPodToURL(podName string, namespace string, url string, clientset *kubernetes.Clientset,
+	config *restclient.Config) bool {
+	glog.Infof("====== Trying to request from '%s' pod => '%s' ======", podName, url)
+	_, err := getHTTPResponseFromPod(podName, namespace, url, clientset, config)
+	if err != nil {
+		glog.Info(err)
 		return false
 	}
 